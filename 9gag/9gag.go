@@ -6,6 +6,7 @@ import (
 	"net/url"
 
 	"github.com/go-chat-bot/bot"
+	"github.com/go-chat-bot/plugins/origin"
 )
 
 const (
@@ -34,5 +35,5 @@ func init() {
 		"9gag",
 		"Returns a random 9gag page.",
 		"",
-		randomPage)
+		origin.Command("9gag", randomPage))
 }
@@ -1,86 +1,300 @@
 package bitly
 
 import (
-	"bytes"
-	"encoding/json"
 	"fmt"
 	"github.com/go-chat-bot/bot"
-	"io/ioutil"
+	"github.com/go-chat-bot/plugins/origin"
 	"log"
 	"mvdan.cc/xurls/v2"
 	"net/http"
+	"net/url"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
-type shortenRequest struct {
-	LongURL string `json:"long_url"`
-}
-
-type shortenReply struct {
-	Link string `json:"link"`
-}
-
 const (
-	bitlyTokenEnv = "BITLY_TOKEN"
-	shortenURLAPI = "https://api-ssl.bitly.com/v4/shorten"
+	backendEnv         = "SHORTENER_BACKEND"
+	bitlyTokenEnv      = "BITLY_TOKEN"
+	yourlsAPIURLEnv    = "YOURLS_API_URL"
+	yourlsSignatureEnv = "YOURLS_SIGNATURE"
+	shlinkURLEnv       = "SHLINK_URL"
+	shlinkAPIKeyEnv    = "SHLINK_API_KEY"
+	localFileEnv       = "SHORTENER_FILE"
+	localBaseURLEnv    = "SHORTENER_BASE_URL"
+	localListenEnv     = "SHORTENER_LISTEN"
+	minLengthEnv       = "SHORTENER_MIN_LENGTH"
+	skipHostsEnv       = "SHORTENER_SKIP_HOSTS"
+	cacheFileEnv       = "SHORTENER_CACHE_FILE"
+	concurrencyEnv     = "SHORTENER_CONCURRENCY"
+	timeoutEnv         = "SHORTENER_TIMEOUT"
+	shortenURLAPI      = "https://api-ssl.bitly.com/v4/shorten"
+	defaultMinLength   = 40
+	defaultConcurrency = 4
+	defaultTimeout     = 5 // seconds
 )
 
 var (
-	urlRegex = xurls.Strict()
+	urlRegex       = xurls.Strict()
+	backend        shortener
+	cache          = &urlCache{links: make(map[string]string)}
+	minLength      = defaultMinLength
+	messageTimeout = defaultTimeout * time.Second
+	// apiSlots bounds the number of concurrent requests to the backend
+	apiSlots = make(chan struct{}, defaultConcurrency)
+	// skipHosts are never shortened, they are short already
+	skipHosts = []string{
+		"bit.ly", "bitly.com", "j.mp", "goo.gl", "t.co", "tinyurl.com",
+		"git.io", "youtu.be", "ow.ly", "is.gd", "buff.ly",
+	}
 )
 
-func shorten(longurl string) (string, error) {
-	sr := shortenRequest{longurl}
-	body, err := json.Marshal(sr)
-	if err != nil {
-		return "", err
+type shortenResult struct {
+	longURL  string
+	shortURL string
+	err      error
+}
+
+// requireEnv returns values of all given env variables or error if any of
+// them is not set
+func requireEnv(names ...string) ([]string, error) {
+	values := make([]string, len(names))
+	for i, name := range names {
+		values[i] = os.Getenv(name)
+		if values[i] == "" {
+			return nil, fmt.Errorf("%s env variable is not set", name)
+		}
 	}
-	req, err := http.NewRequest("POST", shortenURLAPI, bytes.NewBuffer(body))
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s",
-		os.Getenv(bitlyTokenEnv)))
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return "", err
+	return values, nil
+}
+
+// newShortener creates backend of given name configured from env variables
+func newShortener(name string) (shortener, error) {
+	switch name {
+	case "", "bitly":
+		env, err := requireEnv(bitlyTokenEnv)
+		if err != nil {
+			return nil, err
+		}
+		return &bitlyShortener{apiURL: shortenURLAPI, token: env[0]}, nil
+	case "yourls":
+		env, err := requireEnv(yourlsAPIURLEnv, yourlsSignatureEnv)
+		if err != nil {
+			return nil, err
+		}
+		return &yourlsShortener{apiURL: env[0], signature: env[1]}, nil
+	case "shlink":
+		env, err := requireEnv(shlinkURLEnv, shlinkAPIKeyEnv)
+		if err != nil {
+			return nil, err
+		}
+		return &shlinkShortener{
+			baseURL: strings.TrimSuffix(env[0], "/"),
+			apiKey:  env[1],
+		}, nil
+	case "local":
+		env, err := requireEnv(localFileEnv, localBaseURLEnv)
+		if err != nil {
+			return nil, err
+		}
+		return newLocalShortener(env[1], env[0])
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != 200 && resp.StatusCode != 201 {
-		return "", fmt.Errorf("bitly API request returned non-20x code: %d", resp.StatusCode)
+	return nil, fmt.Errorf("unknown URL shortener backend: %s", name)
+}
+
+// backendHost returns host the backend's short URLs live on, if known
+func backendHost(s shortener) string {
+	var base string
+	switch b := s.(type) {
+	case *yourlsShortener:
+		base = b.apiURL
+	case *shlinkShortener:
+		base = b.baseURL
+	case *localShortener:
+		base = b.baseURL
 	}
-	body, err = ioutil.ReadAll(resp.Body)
+	parsedURL, err := url.Parse(base)
 	if err != nil {
-		log.Printf("Error reading response body. ", err)
+		return ""
+	}
+	return parsedURL.Hostname()
+}
+
+func skippedHost(host string) bool {
+	host = strings.ToLower(host)
+	for _, skip := range skipHosts {
+		if host == skip || strings.HasSuffix(host, "."+skip) {
+			return true
+		}
 	}
-	shortReply := shortenReply{}
-	err = json.Unmarshal(body, &shortReply)
+	return false
+}
+
+// candidateURLs returns distinct URLs from message worth shortening, longest
+// first so that replacing them doesn't break longer URLs sharing a prefix
+func candidateURLs(message string) []string {
+	var urls []string
+	seen := make(map[string]bool)
+	for _, longURL := range urlRegex.FindAllString(message, -1) {
+		if seen[longURL] || len(longURL) < minLength {
+			continue
+		}
+		seen[longURL] = true
+		parsedURL, err := url.Parse(longURL)
+		if err != nil || skippedHost(parsedURL.Hostname()) {
+			continue
+		}
+		urls = append(urls, longURL)
+	}
+	sort.SliceStable(urls, func(i, j int) bool {
+		return len(urls[i]) > len(urls[j])
+	})
+	return urls
+}
+
+// shortenCached returns short URL from cache or asks the backend, waiting for
+// a free API slot first. Empty short URLs are never cached.
+func shortenCached(longURL string) (string, error) {
+	if shortURL, found := cache.get(longURL); found && shortURL != "" {
+		return shortURL, nil
+	}
+	slots := apiSlots
+	slots <- struct{}{}
+	defer func() { <-slots }()
+
+	shortURL, err := backend.Shorten(longURL)
 	if err != nil {
 		return "", err
 	}
-	return shortReply.Link, nil
+	if shortURL == "" {
+		return "", fmt.Errorf("backend returned empty short URL")
+	}
+	log.Printf("Succesfully shortened URL (%s) to %s", longURL, shortURL)
+	if err := cache.put(longURL, shortURL); err != nil {
+		log.Printf("Failed to save URL shortener cache: %v", err)
+	}
+	return shortURL, nil
+}
+
+// shortenAll shortens URLs concurrently and returns long -> short map of
+// those done within timeout. Late results still end up in the cache.
+func shortenAll(urls []string, timeout time.Duration) map[string]string {
+	results := make(chan shortenResult, len(urls))
+	for _, longURL := range urls {
+		go func(longURL string) {
+			shortURL, err := shortenCached(longURL)
+			results <- shortenResult{longURL, shortURL, err}
+		}(longURL)
+	}
+
+	shortURLs := make(map[string]string)
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for range urls {
+		select {
+		case result := <-results:
+			if result.err != nil {
+				log.Printf("Failed to shorten URL (%s): %s", result.longURL,
+					result.err.Error())
+				continue
+			}
+			shortURLs[result.longURL] = result.shortURL
+		case <-timer.C:
+			log.Printf("Timed out shortening URLs, sending %d of %d shortened",
+				len(shortURLs), len(urls))
+			return shortURLs
+		}
+	}
+	return shortURLs
 }
 
 func bitlyFilter(cmd *bot.FilterCmd) (string, error) {
-	urls := urlRegex.FindAllString(cmd.Message, -1)
+	if backend == nil {
+		return cmd.Message, nil
+	}
+	plugin := origin.Lookup(cmd)
+	if !shouldShorten(cmd.Target, plugin) {
+		return cmd.Message, nil
+	}
+	urls := candidateURLs(cmd.Message)
 	if urls == nil {
 		// no urls to shorten
 		return cmd.Message, nil
 	}
 
-	for _, url := range urls {
-		shortURL, err := shorten(url)
-		if err != nil {
-			log.Printf("Failed to shorten URL (%s): %s", url, err.Error())
-			continue
+	shortURLs := shortenAll(urls, messageTimeout)
+	message := cmd.Message
+	for _, longURL := range urls {
+		if shortURL, found := shortURLs[longURL]; found && shortURL != "" {
+			message = strings.Replace(message,
+				longURL, shortURL, -1)
 		}
-		log.Printf("Succesfully shortened URL (%s) to %s", url, shortURL)
-		cmd.Message = strings.Replace(cmd.Message,
-			url, shortURL, -1)
 	}
+	return message, nil
+}
 
-	return cmd.Message, nil
+// envInt returns value of numeric env variable or def if it is not set or
+// invalid
+func envInt(name string, def int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		log.Printf("Invalid %s value %q, using default %d", name, value, def)
+		return def
+	}
+	return n
 }
 
 func init() {
+	var err error
+	backend, err = newShortener(os.Getenv(backendEnv))
+	if err != nil {
+		log.Printf("Failed to set up URL shortener, URLs won't be shortened: %v", err)
+		return
+	}
+	if filename := os.Getenv(channelConfigEnv); filename != "" {
+		if err := loadChannelConfigs(filename); err != nil {
+			log.Printf("Failed to load URL shortener configuration %s, URLs won't be shortened: %v",
+				filename, err)
+			return
+		}
+		log.Printf("URL shortening enabled for %d targets", len(channelConfigs))
+	}
+
+	minLength = envInt(minLengthEnv, defaultMinLength)
+	messageTimeout = time.Duration(envInt(timeoutEnv, defaultTimeout)) * time.Second
+	if concurrency := envInt(concurrencyEnv, defaultConcurrency); concurrency > 0 {
+		apiSlots = make(chan struct{}, concurrency)
+	}
+	for _, host := range strings.Split(os.Getenv(skipHostsEnv), ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			skipHosts = append(skipHosts, host)
+		}
+	}
+	if host := backendHost(backend); host != "" {
+		skipHosts = append(skipHosts, host)
+	}
+	cache, err = newURLCache(os.Getenv(cacheFileEnv))
+	if err != nil {
+		log.Printf("Failed to load URL shortener cache, starting empty: %v", err)
+		cache = &urlCache{file: cache.file, links: make(map[string]string)}
+	}
+
+	if local, ok := backend.(*localShortener); ok {
+		if listen := os.Getenv(localListenEnv); listen != "" {
+			go func() {
+				log.Printf("Serving short URL redirects on %s", listen)
+				err := http.ListenAndServe(listen, local)
+				log.Printf("Short URL redirect server stopped: %v", err)
+			}()
+		}
+	}
+
 	bot.RegisterFilterCommand(
 		"bitly",
 		bitlyFilter)
@@ -0,0 +1,443 @@
+package bitly
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/go-chat-bot/bot"
+	"github.com/go-chat-bot/plugins/origin"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeShortener shortens URLs by their length and remembers them
+type fakeShortener struct {
+	sync.Mutex
+	delay     time.Duration
+	shortened []string
+	active    int
+	maxActive int
+}
+
+func (f *fakeShortener) Shorten(longURL string) (string, error) {
+	f.Lock()
+	f.active++
+	if f.active > f.maxActive {
+		f.maxActive = f.active
+	}
+	f.Unlock()
+	time.Sleep(f.delay)
+	f.Lock()
+	defer f.Unlock()
+	f.active--
+	if strings.Contains(longURL, "fail") {
+		return "", fmt.Errorf("failed")
+	}
+	if strings.Contains(longURL, "empty") {
+		return "", nil
+	}
+	f.shortened = append(f.shortened, longURL)
+	return fmt.Sprintf("https://sho.rt/%d", len(longURL)), nil
+}
+
+func (f *fakeShortener) calls() []string {
+	f.Lock()
+	defer f.Unlock()
+	return append([]string{}, f.shortened...)
+}
+
+func TestBitly(t *testing.T) {
+	var request *http.Request
+	requestBody := ""
+	apiResult := ""
+	apiStatus := http.StatusOK
+
+	ts := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			request = r
+			body, _ := ioutil.ReadAll(r.Body)
+			requestBody = string(body)
+			w.WriteHeader(apiStatus)
+			fmt.Fprintln(w, apiResult)
+		}))
+	defer ts.Close()
+
+	Convey("Given a shortener backend", t, func() {
+		Reset(func() {
+			request = nil
+			requestBody = ""
+			apiResult = ""
+			apiStatus = http.StatusOK
+		})
+
+		Convey("Bitly sends token and long URL", func() {
+			apiResult = `{"link": "https://bit.ly/abc"}`
+			b := &bitlyShortener{apiURL: ts.URL, token: "secret"}
+
+			shortURL, err := b.Shorten("https://golang.org/doc")
+
+			So(err, ShouldBeNil)
+			So(shortURL, ShouldEqual, "https://bit.ly/abc")
+			So(request.Header.Get("Authorization"), ShouldEqual, "Bearer secret")
+			So(requestBody, ShouldEqual, `{"long_url":"https://golang.org/doc"}`)
+		})
+
+		Convey("Bitly reports API errors", func() {
+			apiStatus = http.StatusForbidden
+			b := &bitlyShortener{apiURL: ts.URL, token: "secret"}
+
+			_, err := b.Shorten("https://golang.org/doc")
+
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Bitly reports replies without link", func() {
+			apiResult = `{}`
+			b := &bitlyShortener{apiURL: ts.URL, token: "secret"}
+
+			_, err := b.Shorten("https://golang.org/doc")
+
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("YOURLS sends signature and long URL", func() {
+			apiResult = `{"status": "success", "shorturl": "https://s.example.com/1"}`
+			y := &yourlsShortener{apiURL: ts.URL + "/yourls-api.php", signature: "sig"}
+
+			shortURL, err := y.Shorten("https://golang.org/doc")
+
+			So(err, ShouldBeNil)
+			So(shortURL, ShouldEqual, "https://s.example.com/1")
+			So(request.URL.Path, ShouldEqual, "/yourls-api.php")
+			So(requestBody, ShouldContainSubstring, "signature=sig")
+			So(requestBody, ShouldContainSubstring, "url=https%3A%2F%2Fgolang.org%2Fdoc")
+		})
+
+		Convey("YOURLS returns URLs which were shortened before", func() {
+			apiResult = `{"status": "fail", "code": "error:url", "shorturl": "https://s.example.com/1"}`
+			y := &yourlsShortener{apiURL: ts.URL, signature: "sig"}
+
+			shortURL, err := y.Shorten("https://golang.org/doc")
+
+			So(err, ShouldBeNil)
+			So(shortURL, ShouldEqual, "https://s.example.com/1")
+		})
+
+		Convey("YOURLS reports failures", func() {
+			apiResult = `{"status": "fail", "message": "no"}`
+			y := &yourlsShortener{apiURL: ts.URL, signature: "sig"}
+
+			_, err := y.Shorten("https://golang.org/doc")
+
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Shlink sends API key and long URL", func() {
+			apiResult = `{"shortUrl": "https://s.example.com/abc"}`
+			s := &shlinkShortener{baseURL: ts.URL, apiKey: "key"}
+
+			shortURL, err := s.Shorten("https://golang.org/doc")
+
+			So(err, ShouldBeNil)
+			So(shortURL, ShouldEqual, "https://s.example.com/abc")
+			So(request.URL.Path, ShouldEqual, "/rest/v3/short-urls")
+			So(request.Header.Get("X-Api-Key"), ShouldEqual, "key")
+			So(requestBody, ShouldEqual,
+				`{"longUrl":"https://golang.org/doc","findIfExists":true}`)
+		})
+
+		Convey("Shlink reports replies without short URL", func() {
+			apiResult = `{}`
+			s := &shlinkShortener{baseURL: ts.URL, apiKey: "key"}
+
+			_, err := s.Shorten("https://golang.org/doc")
+
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given a local shortener", t, func() {
+		dir, _ := ioutil.TempDir("", "shortener")
+		file := filepath.Join(dir, "links.json")
+		Reset(func() {
+			os.RemoveAll(dir)
+		})
+		local, err := newLocalShortener("https://s.example.com/", file)
+		So(err, ShouldBeNil)
+
+		Convey("Every long URL gets its own code", func() {
+			first, err := local.Shorten("https://golang.org")
+			So(err, ShouldBeNil)
+			second, err := local.Shorten("https://go.dev")
+			So(err, ShouldBeNil)
+			again, err := local.Shorten("https://golang.org")
+			So(err, ShouldBeNil)
+
+			So(first, ShouldEqual, "https://s.example.com/0")
+			So(second, ShouldEqual, "https://s.example.com/1")
+			So(again, ShouldEqual, first)
+		})
+
+		Convey("Mappings are stored in the file", func() {
+			local.Shorten("https://golang.org")
+
+			data, _ := ioutil.ReadFile(file)
+			links := map[string]string{}
+			So(json.Unmarshal(data, &links), ShouldBeNil)
+			So(links, ShouldResemble, map[string]string{"0": "https://golang.org"})
+
+			reloaded, err := newLocalShortener("https://s.example.com", file)
+			So(err, ShouldBeNil)
+			shortURL, _ := reloaded.Shorten("https://golang.org")
+			So(shortURL, ShouldEqual, "https://s.example.com/0")
+			shortURL, _ = reloaded.Shorten("https://go.dev")
+			So(shortURL, ShouldEqual, "https://s.example.com/1")
+		})
+
+		Convey("Short URLs are redirected", func() {
+			local.Shorten("https://golang.org")
+
+			w := httptest.NewRecorder()
+			local.ServeHTTP(w, httptest.NewRequest("GET", "/0", nil))
+			So(w.Code, ShouldEqual, http.StatusMovedPermanently)
+			So(w.Header().Get("Location"), ShouldEqual, "https://golang.org")
+
+			w = httptest.NewRecorder()
+			local.ServeHTTP(w, httptest.NewRequest("GET", "/1", nil))
+			So(w.Code, ShouldEqual, http.StatusNotFound)
+		})
+
+		Convey("Codes use the whole alphabet", func() {
+			So(encodeCode(0), ShouldEqual, "0")
+			So(encodeCode(61), ShouldEqual, "Z")
+			So(encodeCode(62), ShouldEqual, "10")
+		})
+	})
+
+	Convey("Given a backend name", t, func() {
+		Convey("Bitly is the default", func() {
+			os.Setenv(bitlyTokenEnv, "secret")
+			defer os.Unsetenv(bitlyTokenEnv)
+
+			s, err := newShortener("")
+
+			So(err, ShouldBeNil)
+			So(s, ShouldHaveSameTypeAs, &bitlyShortener{})
+		})
+
+		Convey("Bitly without token is reported", func() {
+			os.Unsetenv(bitlyTokenEnv)
+
+			_, err := newShortener("bitly")
+
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Missing configuration is reported", func() {
+			_, err := newShortener("yourls")
+
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Unknown backends are reported", func() {
+			_, err := newShortener("tinyurl")
+
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given a message with URLs", t, func() {
+		fake := &fakeShortener{}
+		backend = fake
+		minLength = 0
+		Reset(func() {
+			backend = nil
+			cache = &urlCache{links: make(map[string]string)}
+			minLength = defaultMinLength
+			messageTimeout = defaultTimeout * time.Second
+			apiSlots = make(chan struct{}, defaultConcurrency)
+		})
+		cmd := &bot.FilterCmd{
+			Target:  "#go",
+			Message: "see https://golang.org/doc and https://fail.example.com",
+		}
+
+		Convey("They are shortened", func() {
+			message, err := bitlyFilter(cmd)
+
+			So(err, ShouldBeNil)
+			So(message, ShouldEqual, "see https://sho.rt/22 and https://fail.example.com")
+			So(fake.calls(), ShouldResemble, []string{"https://golang.org/doc"})
+		})
+
+		Convey("Short URLs are left alone", func() {
+			minLength = 23
+			cmd.Message = "see https://golang.org/doc and https://golang.org/doc/"
+
+			message, err := bitlyFilter(cmd)
+
+			So(err, ShouldBeNil)
+			So(message, ShouldEqual, "see https://golang.org/doc and https://sho.rt/23")
+		})
+
+		Convey("URLs sharing a prefix are shortened correctly", func() {
+			cmd.Message = "https://golang.org/doc https://golang.org/doc/faq"
+
+			message, err := bitlyFilter(cmd)
+
+			So(err, ShouldBeNil)
+			So(message, ShouldEqual, "https://sho.rt/22 https://sho.rt/26")
+		})
+
+		Convey("Links to URL shorteners are skipped", func() {
+			cmd.Message = "https://bit.ly/abcdef and https://www.tinyurl.com/abc"
+
+			message, err := bitlyFilter(cmd)
+
+			So(err, ShouldBeNil)
+			So(message, ShouldEqual, cmd.Message)
+			So(fake.calls(), ShouldBeEmpty)
+		})
+
+		Convey("Each URL is shortened only once", func() {
+			cmd.Message = "https://golang.org/doc https://golang.org/doc"
+			bitlyFilter(cmd)
+			cmd.Message = "again https://golang.org/doc"
+
+			message, err := bitlyFilter(cmd)
+
+			So(err, ShouldBeNil)
+			So(message, ShouldEqual, "again https://sho.rt/22")
+			So(fake.calls(), ShouldResemble, []string{"https://golang.org/doc"})
+		})
+
+		Convey("Cache is kept on disk", func() {
+			dir, _ := ioutil.TempDir("", "shortener")
+			Reset(func() {
+				os.RemoveAll(dir)
+			})
+			file := filepath.Join(dir, "cache.json")
+			cache, _ = newURLCache(file)
+			bitlyFilter(cmd)
+
+			reloaded, err := newURLCache(file)
+
+			So(err, ShouldBeNil)
+			shortURL, found := reloaded.get("https://golang.org/doc")
+			So(found, ShouldBeTrue)
+			So(shortURL, ShouldEqual, "https://sho.rt/22")
+		})
+
+		Convey("Empty short URLs are neither used nor cached", func() {
+			cmd.Message = "see https://empty.example.com"
+			cache.put("https://golang.org/doc", "")
+
+			message, err := bitlyFilter(cmd)
+
+			So(err, ShouldBeNil)
+			So(message, ShouldEqual, cmd.Message)
+			_, found := cache.get("https://empty.example.com")
+			So(found, ShouldBeFalse)
+
+			cmd.Message = "see https://golang.org/doc"
+			message, err = bitlyFilter(cmd)
+
+			So(err, ShouldBeNil)
+			So(message, ShouldEqual, "see https://sho.rt/22")
+		})
+
+		Convey("Slow backend doesn't hold the message", func() {
+			fake.delay = 200 * time.Millisecond
+			messageTimeout = 10 * time.Millisecond
+			cmd.Message = "see https://golang.org/doc"
+
+			message, err := bitlyFilter(cmd)
+
+			So(err, ShouldBeNil)
+			So(message, ShouldEqual, "see https://golang.org/doc")
+
+			shortURL, found := "", false
+			for i := 0; i < 100 && !found; i++ {
+				time.Sleep(10 * time.Millisecond)
+				shortURL, found = cache.get("https://golang.org/doc")
+			}
+			So(found, ShouldBeTrue)
+			So(shortURL, ShouldEqual, "https://sho.rt/22")
+		})
+
+		Convey("Concurrent requests are bounded", func() {
+			fake.delay = 20 * time.Millisecond
+			apiSlots = make(chan struct{}, 2)
+			cmd.Message = "https://a.example.com https://b.example.com " +
+				"https://c.example.com https://d.example.com https://e.example.com"
+
+			message, err := bitlyFilter(cmd)
+
+			So(err, ShouldBeNil)
+			So(message, ShouldNotContainSubstring, "example.com")
+			So(fake.maxActive, ShouldEqual, 2)
+		})
+	})
+
+	Convey("Given per channel configuration", t, func() {
+		fake := &fakeShortener{}
+		backend = fake
+		minLength = 0
+		dir, _ := ioutil.TempDir("", "shortener")
+		Reset(func() {
+			os.RemoveAll(dir)
+			backend = nil
+			cache = &urlCache{links: make(map[string]string)}
+			minLength = defaultMinLength
+			channelConfigs = nil
+			origin.Clear()
+		})
+		file := filepath.Join(dir, "config.json")
+		ioutil.WriteFile(file, []byte(`[
+			{"target": "#*", "exceptPlugins": ["url"]},
+			{"target": "#jira", "plugins": ["jira"]},
+			{"target": "[invalid"}
+		]`), 0600)
+		So(loadChannelConfigs(file), ShouldBeNil)
+		So(channelConfigs, ShouldHaveLength, 2)
+		var cmd *bot.FilterCmd
+		filter := func(target, message, plugin string) string {
+			origin.Record(plugin, target, message)
+			cmd = &bot.FilterCmd{Target: target, Message: message}
+			filtered, err := bitlyFilter(cmd)
+			So(err, ShouldBeNil)
+			return filtered
+		}
+
+		Convey("Matching channels are shortened", func() {
+			So(filter("#go", "https://golang.org/doc", ""), ShouldEqual, "https://sho.rt/22")
+		})
+
+		Convey("Other channels are not shortened", func() {
+			So(filter("C024BE91L", "https://golang.org/doc", ""), ShouldEqual, "https://golang.org/doc")
+		})
+
+		Convey("Excluded plugins are not shortened", func() {
+			So(filter("#go", "https://golang.org/doc", "url"), ShouldEqual, "https://golang.org/doc")
+		})
+
+		Convey("Exact channel name takes precedence over patterns", func() {
+			So(filter("#jira", "https://golang.org/doc", "jira"), ShouldEqual, "https://sho.rt/22")
+			So(filter("#jira", "https://golang.org/doc/faq", "cachet"), ShouldEqual, "https://golang.org/doc/faq")
+			So(filter("#jira", "https://golang.org/doc/go1", ""), ShouldEqual, "https://golang.org/doc/go1")
+		})
+
+		Convey("Shortened message keeps its origin", func() {
+			cmd.Message = filter("#go", "https://golang.org/doc", "jira")
+
+			So(origin.Lookup(cmd), ShouldEqual, "jira")
+		})
+	})
+}
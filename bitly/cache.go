@@ -0,0 +1,72 @@
+package bitly
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// writeJSONFile writes v to a temporary file first, so that a crash can't
+// leave a truncated file behind
+func writeJSONFile(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".shortener")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// urlCache remembers short versions of long URLs, optionally persisting them
+// in a JSON file
+type urlCache struct {
+	sync.RWMutex
+	file  string            // empty for memory only cache
+	links map[string]string // long URL -> short URL
+}
+
+func newURLCache(file string) (*urlCache, error) {
+	c := &urlCache{file: file, links: make(map[string]string)}
+	if file == "" {
+		return c, nil
+	}
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return c, err
+	}
+	err = json.Unmarshal(data, &c.links)
+	return c, err
+}
+
+func (c *urlCache) get(longURL string) (string, bool) {
+	c.RLock()
+	defer c.RUnlock()
+	shortURL, found := c.links[longURL]
+	return shortURL, found
+}
+
+func (c *urlCache) put(longURL, shortURL string) error {
+	c.Lock()
+	defer c.Unlock()
+	c.links[longURL] = shortURL
+	if c.file == "" {
+		return nil
+	}
+	return writeJSONFile(c.file, c.links)
+}
@@ -0,0 +1,85 @@
+package bitly
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path"
+)
+
+const channelConfigEnv = "SHORTENER_CONFIG_FILE"
+
+// channelConfig enables shortening in targets matching Target
+type channelConfig struct {
+	Target        string   `json:"target"`                  // channel name or pattern, e.g. #* for all IRC channels
+	Plugins       []string `json:"plugins,omitempty"`       // if set, only output of these plugins is shortened
+	ExceptPlugins []string `json:"exceptPlugins,omitempty"` // output of these plugins is never shortened
+}
+
+// channelConfigs is nil when shortening is enabled everywhere
+var channelConfigs []channelConfig
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// getChannelConfig returns configuration of target. Exact target names take
+// precedence over patterns, patterns are tried in the order of the file.
+func getChannelConfig(target string) *channelConfig {
+	for i := range channelConfigs {
+		if channelConfigs[i].Target == target {
+			return &channelConfigs[i]
+		}
+	}
+	for i := range channelConfigs {
+		matched, err := path.Match(channelConfigs[i].Target, target)
+		if err == nil && matched {
+			return &channelConfigs[i]
+		}
+	}
+	return nil
+}
+
+// shouldShorten reports whether URLs in message produced by plugin (empty if
+// not known) and sent to target should be shortened
+func shouldShorten(target, plugin string) bool {
+	if channelConfigs == nil {
+		return true
+	}
+	config := getChannelConfig(target)
+	if config == nil || contains(config.ExceptPlugins, plugin) {
+		return false
+	}
+	return len(config.Plugins) == 0 || contains(config.Plugins, plugin)
+}
+
+func loadChannelConfigs(filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	configs := make([]channelConfig, 0)
+	err = json.NewDecoder(file).Decode(&configs)
+	if err != nil {
+		return err
+	}
+	channelConfigs = make([]channelConfig, 0, len(configs))
+	for _, config := range configs {
+		if config.Target == "" {
+			log.Println("Configuration without target found. Skipping")
+			continue
+		}
+		if _, err := path.Match(config.Target, ""); err != nil {
+			log.Printf("Invalid target pattern %q. Skipping", config.Target)
+			continue
+		}
+		channelConfigs = append(channelConfigs, config)
+	}
+	return nil
+}
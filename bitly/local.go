@@ -0,0 +1,103 @@
+package bitly
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+const (
+	codeAlphabet = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+)
+
+// localShortener keeps mappings in a local JSON file and serves the
+// redirects itself (see ServeHTTP)
+type localShortener struct {
+	sync.RWMutex
+	baseURL string            // public URL of the redirect handler
+	file    string            // path to file with mappings
+	links   map[string]string // code -> long URL
+	codes   map[string]string // long URL -> code
+}
+
+// encodeCode turns sequence number into short base62 code
+func encodeCode(n int) string {
+	base := len(codeAlphabet)
+	code := ""
+	for {
+		code = string(codeAlphabet[n%base]) + code
+		n /= base
+		if n == 0 {
+			return code
+		}
+	}
+}
+
+func newLocalShortener(baseURL, file string) (*localShortener, error) {
+	l := &localShortener{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		file:    file,
+		links:   make(map[string]string),
+		codes:   make(map[string]string),
+	}
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, &l.links)
+	if err != nil {
+		return nil, err
+	}
+	for code, longURL := range l.links {
+		l.codes[longURL] = code
+	}
+	return l, nil
+}
+
+func (l *localShortener) save() error {
+	return writeJSONFile(l.file, l.links)
+}
+
+func (l *localShortener) Shorten(longURL string) (string, error) {
+	l.Lock()
+	defer l.Unlock()
+	code, found := l.codes[longURL]
+	if !found {
+		for n := len(l.links); ; n++ {
+			code = encodeCode(n)
+			if _, taken := l.links[code]; !taken {
+				break
+			}
+		}
+		l.links[code] = longURL
+		l.codes[longURL] = code
+		if err := l.save(); err != nil {
+			delete(l.links, code)
+			delete(l.codes, longURL)
+			return "", err
+		}
+	}
+	return l.baseURL + "/" + code, nil
+}
+
+// ServeHTTP redirects short URLs to their long versions
+func (l *localShortener) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	l.RLock()
+	longURL, found := l.links[strings.TrimPrefix(r.URL.Path, "/")]
+	l.RUnlock()
+	if !found {
+		http.NotFound(w, r)
+		return
+	}
+	http.Redirect(w, r, longURL, http.StatusMovedPermanently)
+}
@@ -0,0 +1,149 @@
+package bitly
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	requestTimeout = 10 * time.Second
+)
+
+var (
+	httpClient = &http.Client{Timeout: requestTimeout}
+)
+
+// shortener turns long URLs into short ones
+type shortener interface {
+	Shorten(longURL string) (string, error)
+}
+
+// postJSON sends request as JSON and decodes JSON reply into reply
+func postJSON(req *http.Request, request, reply interface{}) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	req.Header.Set("Content-Type", "application/json")
+	return doJSON(req, reply)
+}
+
+// doJSON sends request and decodes JSON reply into reply
+func doJSON(req *http.Request, reply interface{}) error {
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 && resp.StatusCode != 201 {
+		return fmt.Errorf("%s request returned non-20x code: %d",
+			req.URL.Host, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(reply)
+}
+
+// bitlyShortener uses bit.ly API v4
+type bitlyShortener struct {
+	apiURL string
+	token  string
+}
+
+type bitlyRequest struct {
+	LongURL string `json:"long_url"`
+}
+
+type bitlyReply struct {
+	Link string `json:"link"`
+}
+
+func (b *bitlyShortener) Shorten(longURL string) (string, error) {
+	req, err := http.NewRequest("POST", b.apiURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", b.token))
+	reply := bitlyReply{}
+	err = postJSON(req, bitlyRequest{longURL}, &reply)
+	if err != nil {
+		return "", err
+	}
+	if reply.Link == "" {
+		return "", fmt.Errorf("Bitly returned no short URL")
+	}
+	return reply.Link, nil
+}
+
+// yourlsShortener uses API of self-hosted YOURLS (https://yourls.org)
+type yourlsShortener struct {
+	apiURL    string // URL of yourls-api.php
+	signature string // secret signature token
+}
+
+type yourlsReply struct {
+	Status   string `json:"status"`
+	Message  string `json:"message"`
+	ShortURL string `json:"shorturl"`
+}
+
+func (y *yourlsShortener) Shorten(longURL string) (string, error) {
+	form := url.Values{}
+	form.Set("signature", y.signature)
+	form.Set("action", "shorturl")
+	form.Set("format", "json")
+	form.Set("url", longURL)
+	req, err := http.NewRequest("POST", y.apiURL,
+		bytes.NewBufferString(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	reply := yourlsReply{}
+	err = doJSON(req, &reply)
+	if err != nil {
+		return "", err
+	}
+	// already shortened URLs are reported as failure, but with short URL
+	if reply.ShortURL == "" {
+		return "", fmt.Errorf("YOURLS failed to shorten URL: %s", reply.Message)
+	}
+	return reply.ShortURL, nil
+}
+
+// shlinkShortener uses REST API of self-hosted Shlink (https://shlink.io)
+type shlinkShortener struct {
+	baseURL string // URL of Shlink server
+	apiKey  string
+}
+
+type shlinkRequest struct {
+	LongURL      string `json:"longUrl"`
+	FindIfExists bool   `json:"findIfExists"`
+}
+
+type shlinkReply struct {
+	ShortURL string `json:"shortUrl"`
+}
+
+func (s *shlinkShortener) Shorten(longURL string) (string, error) {
+	req, err := http.NewRequest("POST", s.baseURL+"/rest/v3/short-urls", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Api-Key", s.apiKey)
+	reply := shlinkReply{}
+	err = postJSON(req, shlinkRequest{longURL, true}, &reply)
+	if err != nil {
+		return "", err
+	}
+	if reply.ShortURL == "" {
+		return "", fmt.Errorf("Shlink returned no short URL")
+	}
+	return reply.ShortURL, nil
+}
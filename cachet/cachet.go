@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/go-chat-bot/bot"
+	"github.com/go-chat-bot/plugins/origin"
 	"io/ioutil"
 	"log"
 	"net/http"
@@ -370,33 +371,33 @@ func init() {
 
 	bot.RegisterPeriodicCommandV2(
 		"systemStatusCheck",
-		bot.PeriodicConfig{
+		origin.Periodic("cachet", bot.PeriodicConfig{
 			CronSpec:  "@every 1m",
 			CmdFuncV2: checkCachet,
-		})
+		}))
 	bot.RegisterCommandV3(
 		"services",
 		"List services available for subscriptions",
 		"",
-		listComponents)
+		origin.CommandV3("cachet", listComponents))
 	bot.RegisterCommand(
 		"subscriptions",
 		"Lists active outage subscriptions",
 		"",
-		listSubscriptions)
+		origin.Command("cachet", listSubscriptions))
 	bot.RegisterCommand(
 		"subscribe",
 		"Subscribes this channel to outage notifications of specific service (or 'any' for all outages)",
 		"<service>",
-		subscribeChannel)
+		origin.Command("cachet", subscribeChannel))
 	bot.RegisterCommand(
 		"unsubscribe",
 		"Unsubscribes this channel from outage notifications of specific service",
 		"<service>",
-		unsubscribeChannel)
+		origin.Command("cachet", unsubscribeChannel))
 	bot.RegisterCommand(
 		"repeatgap",
 		"Sets number of minutes between notification of specific service outage",
 		"60",
-		outageRepeatGap)
+		origin.Command("cachet", outageRepeatGap))
 }
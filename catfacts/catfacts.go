@@ -3,6 +3,7 @@ package catfacts
 import (
 	"fmt"
 	"github.com/go-chat-bot/bot"
+	"github.com/go-chat-bot/plugins/origin"
 	"github.com/go-chat-bot/plugins/web"
 	"regexp"
 )
@@ -13,13 +14,16 @@ const (
 )
 
 type catFact struct {
-	Fact   string   `json:"fact"`
-	Length int      `json:"length"`
+	Fact   string `json:"fact"`
+	Length int    `json:"length"`
 }
 
 var (
 	re          = regexp.MustCompile(pattern)
 	catFactsURL = "http://catfact.ninja/fact"
+	// every mention of cats asks for a fact, chatty channels mustn't flood
+	// the API
+	catFactsRateLimit = web.RateLimit{PerSecond: 0.5, Burst: 3}
 )
 
 func catFacts(command *bot.PassiveCmd) (string, error) {
@@ -28,6 +32,10 @@ func catFacts(command *bot.PassiveCmd) (string, error) {
 	}
 	data := &catFact{}
 	err := web.GetJSON(catFactsURL, data)
+	if web.IsUnavailable(err) {
+		// nobody asked for the fact, no need to complain
+		return "", nil
+	}
 	if err != nil {
 		return "", err
 	}
@@ -36,10 +44,12 @@ func catFacts(command *bot.PassiveCmd) (string, error) {
 		return "", nil
 	}
 
-	return fmt.Sprintf(msgPrefix, data.Fact), nil}
+	return fmt.Sprintf(msgPrefix, data.Fact), nil
+}
 
 func init() {
+	web.SetHostRateLimit("catfact.ninja", catFactsRateLimit)
 	bot.RegisterPassiveCommand(
 		"catfacts",
-		catFacts)
+		origin.Passive("catfacts", catFacts))
 }
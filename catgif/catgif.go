@@ -2,6 +2,7 @@ package catgif
 
 import (
 	"github.com/go-chat-bot/bot"
+	"github.com/go-chat-bot/plugins/origin"
 	"net/http"
 )
 
@@ -18,5 +19,5 @@ func init() {
 		"catgif",
 		"Returns a random cat gif.",
 		"",
-		gif)
+		origin.Command("catgif", gif))
 }
@@ -2,6 +2,7 @@ package chucknorris
 
 import (
 	"github.com/go-chat-bot/bot"
+	"github.com/go-chat-bot/plugins/origin"
 	"math/rand"
 	"regexp"
 )
@@ -90,5 +91,5 @@ func chucknorris(command *bot.PassiveCmd) (string, error) {
 func init() {
 	bot.RegisterPassiveCommand(
 		"chucknorris",
-		chucknorris)
+		origin.Passive("chucknorris", chucknorris))
 }
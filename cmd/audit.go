@@ -0,0 +1,219 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chat-bot/bot"
+)
+
+const (
+	defaultAuditMaxSize  = 10 * 1024 * 1024 // bytes
+	defaultAuditMaxFiles = 5
+	maxAuditOutput       = 1024 // bytes of output kept in the log
+	defaultHistoryCount  = 10
+	maxHistoryCount      = 50
+	historyUsage         = "Usage: !cmd history [number of entries]"
+)
+
+// auditEntry records a single attempt to run a command
+type auditEntry struct {
+	Time     time.Time `json:"time"`
+	Nick     string    `json:"nick"`
+	Channel  string    `json:"channel"`
+	Command  string    `json:"command"`        // as typed, e.g. "disk home"
+	Argv     []string  `json:"argv,omitempty"` // as executed
+	ExitCode int       `json:"exitCode"`       // -1 if the command didn't exit on its own
+	Duration float64   `json:"duration"`       // seconds
+	Output   string    `json:"output,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// auditLog appends entries to JSON Lines file. When the file would grow over
+// maxSize it is rotated: file.1 is the newest old file, file.<maxFiles> the
+// oldest one kept.
+type auditLog struct {
+	sync.Mutex
+	file     string
+	maxSize  int64
+	maxFiles int
+}
+
+var audit *auditLog
+
+func newAuditEntry(command *bot.Cmd) *auditEntry {
+	return &auditEntry{
+		Time:     time.Now(),
+		Nick:     nick(command),
+		Channel:  command.Channel,
+		Command:  strings.Join(command.Args, " "),
+		ExitCode: -1,
+	}
+}
+
+// appendOutput keeps beginning of the output up to maxAuditOutput bytes
+func (e *auditEntry) appendOutput(output string) {
+	if room := maxAuditOutput - len(e.Output); room > 0 {
+		if len(output) > room {
+			output = output[:room]
+		}
+		e.Output += output
+	}
+}
+
+// finish records how the command ended
+func (e *auditEntry) finish(cmd *exec.Cmd, err error) {
+	e.Duration = time.Since(e.Time).Seconds()
+	if cmd != nil && cmd.ProcessState != nil {
+		e.ExitCode = cmd.ProcessState.ExitCode()
+	}
+	var exitErr *exec.ExitError
+	if err != nil && (!errors.As(err, &exitErr) || e.ExitCode < 0) {
+		// plain exit status is in ExitCode already
+		e.Error = err.Error()
+	}
+}
+
+func (l *auditLog) rotate() error {
+	os.Remove(fmt.Sprintf("%s.%d", l.file, l.maxFiles))
+	for i := l.maxFiles - 1; i >= 1; i-- {
+		err := os.Rename(fmt.Sprintf("%s.%d", l.file, i), fmt.Sprintf("%s.%d", l.file, i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.Rename(l.file, l.file+".1")
+}
+
+func (l *auditLog) write(entry *auditEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	l.Lock()
+	defer l.Unlock()
+	if info, err := os.Stat(l.file); err == nil && info.Size()+int64(len(line)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+	file, err := os.OpenFile(l.file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(line); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// recent returns up to n newest entries of the current file, newest first
+func (l *auditLog) recent(n int) ([]auditEntry, error) {
+	l.Lock()
+	defer l.Unlock()
+	file, err := os.Open(l.file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []auditEntry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 4096), 1024*1024)
+	for scanner.Scan() {
+		var entry auditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		entries = append(entries, entry)
+		if len(entries) > n {
+			entries = entries[1:]
+		}
+	}
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	return entries, scanner.Err()
+}
+
+// record writes entry to the audit log if it is enabled
+func record(entry *auditEntry) {
+	if audit == nil {
+		return
+	}
+	if err := audit.write(entry); err != nil {
+		log.Printf("Failed to write cmd audit log: %v", err)
+	}
+}
+
+// canSeeHistory reports whether nick is allowed to see the audit log. Nobody
+// is unless there is a list of allowed nicks.
+func canSeeHistory(nick string) bool {
+	if pluginConfig == nil {
+		return false
+	}
+	nicks := pluginConfig.AuditNicks
+	if nicks == nil {
+		nicks = pluginConfig.Nicks
+	}
+	return contains(nicks, nick)
+}
+
+func formatAuditEntry(entry auditEntry) string {
+	result := fmt.Sprintf("exit %d, %.1fs", entry.ExitCode, entry.Duration)
+	if entry.Error != "" {
+		result = entry.Error
+	}
+	return fmt.Sprintf("%s %s in %s: %s (%s)", entry.Time.Format("2006-01-02 15:04"),
+		entry.Nick, entry.Channel, entry.Command, result)
+}
+
+func history(command *bot.Cmd) string {
+	if audit == nil {
+		return "Audit log is not configured"
+	}
+	if !canSeeHistory(nick(command)) {
+		return "You are not allowed to see history"
+	}
+	count := defaultHistoryCount
+	if len(command.Args) > 2 {
+		return historyUsage
+	}
+	if len(command.Args) == 2 {
+		n, err := strconv.Atoi(command.Args[1])
+		if err != nil || n <= 0 {
+			return historyUsage
+		}
+		count = n
+		if count > maxHistoryCount {
+			count = maxHistoryCount
+		}
+	}
+	entries, err := audit.recent(count)
+	if err != nil {
+		log.Printf("Failed to read cmd audit log: %v", err)
+	}
+	if len(entries) == 0 {
+		return "No commands were run yet"
+	}
+	lines := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		lines = append(lines, formatAuditEntry(entry))
+	}
+	return strings.Join(lines, "\n")
+}
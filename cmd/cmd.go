@@ -2,71 +2,221 @@ package cmd
 
 import (
 	"bytes"
+	"context"
 	"errors"
+	"fmt"
+	"log"
+	"os"
 	"os/exec"
+	"sort"
+	"strings"
 
 	"github.com/go-chat-bot/bot"
+	"github.com/go-chat-bot/plugins/origin"
 )
 
-var (
-	disableCmds   = map[string]bool{"shutdown": true, "reboot": true, "init": true, "rm": true, "top": true, "htop": true, "iotop": true}
-	errDisableCmd = errors.New("command is disabled")
-)
+const truncatedNote = "(output truncated)"
 
-func cmd(command *bot.Cmd) (string, error) {
-	if _, ok := disableCmds[command.Args[0]]; ok {
-		return "", errDisableCmd
+var errNotAllowed = errors.New("not allowed")
+
+// cappedBuffer keeps at most max bytes written to it and silently drops the
+// rest so that the command doesn't fail writing its output
+type cappedBuffer struct {
+	buffer    bytes.Buffer
+	max       int
+	truncated bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - b.buffer.Len(); len(p) > room {
+		b.truncated = true
+		if room > 0 {
+			b.buffer.Write(p[:room])
+		}
+		return len(p), nil
 	}
-	cmd := exec.Command("/bin/bash", "-c", command.RawArgs)
-	data, err := cmd.CombinedOutput()
-	return string(data), err
+	return b.buffer.Write(p)
 }
 
-func cmdV3(command *bot.Cmd) (result bot.CmdResultV3, err error) {
-	result = bot.CmdResultV3{Message: make(chan string), Done: make(chan bool)}
-	if _, ok := disableCmds[command.Args[0]]; ok {
-		err = errDisableCmd
-		return
+func (b *cappedBuffer) String() string {
+	return b.buffer.String()
+}
+
+func nick(command *bot.Cmd) string {
+	if command.User == nil {
+		return ""
 	}
+	return command.User.Nick
+}
 
-	cmd := exec.Command("/bin/bash", "-c", command.RawArgs)
-	var b bytes.Buffer
-	cmd.Stdout = &b
-	cmd.Stderr = &b
-	err = cmd.Start()
+// available returns names of commands nick can run in channel
+func available(nick, channel string) []string {
+	names := []string{}
+	for name, c := range commands {
+		if c.allowed(nick, channel) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func availableMessage(nick, channel string) string {
+	names := available(nick, channel)
+	if len(names) == 0 {
+		return "There are no commands you can run here"
+	}
+	return "Available commands: " + strings.Join(names, ", ")
+}
+
+// lookup returns configuration of the command requested by arguments or a
+// message explaining why it can't be run
+func lookup(command *bot.Cmd) (*commandConfig, string) {
+	if len(command.Args) == 0 {
+		return nil, availableMessage(nick(command), command.Channel)
+	}
+	c, found := commands[command.Args[0]]
+	if !found {
+		return nil, fmt.Sprintf("Unknown command %s. %s", command.Args[0],
+			availableMessage(nick(command), command.Channel))
+	}
+	if !c.allowed(nick(command), command.Channel) {
+		entry := newAuditEntry(command)
+		entry.finish(nil, errNotAllowed)
+		record(entry)
+		return nil, fmt.Sprintf("You are not allowed to run %s here", c.Name)
+	}
+	return c, ""
+}
+
+// run runs the command with args and returns its output together with notes
+// about failure, timeout or truncation. The run is described in entry.
+func run(c *commandConfig, args []string, entry *auditEntry) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout())
+	defer cancel()
+	cmd, err := c.command(ctx, args)
 	if err != nil {
-		return
+		return "", err
 	}
-	done := false
-	go func() {
-		cmd.Wait()
-		done = true
-		result.Done <- true
-	}()
-	go func() {
-		for {
-			line, _ := b.ReadString('\n')
-			if line != "" {
-				result.Message <- line
-			}
-			if done {
-				break
-			}
+	entry.Argv = cmd.Args
+	output := &cappedBuffer{max: c.MaxOutput}
+	cmd.Stdout = output
+	cmd.Stderr = output
+	err = cmd.Run()
+	entry.appendOutput(output.String())
+	entry.finish(cmd, err)
 
+	lines := []string{strings.TrimRight(output.String(), "\n")}
+	if output.truncated {
+		lines = append(lines, truncatedNote)
+	}
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		lines = append(lines, fmt.Sprintf("(timed out after %s)", c.timeout()))
+	case err != nil:
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			return "", err
 		}
-	}()
-	return
+		lines = append(lines, fmt.Sprintf("(%s)", exitErr))
+	}
+	return strings.TrimLeft(strings.Join(lines, "\n"), "\n"), nil
+}
+
+// builtin handles "jobs", "kill" and "history" subcommands, ok is false for
+// other commands
+func builtin(command *bot.Cmd) (message string, ok bool) {
+	if len(command.Args) == 0 {
+		return "", false
+	}
+	switch command.Args[0] {
+	case "jobs":
+		return jobsMessage(command.Channel), true
+	case "kill":
+		return killJob(command), true
+	case "history":
+		return history(command), true
+	}
+	return "", false
+}
+
+func cmd(command *bot.Cmd) (string, error) {
+	if message, ok := builtin(command); ok {
+		return message, nil
+	}
+	c, message := lookup(command)
+	if c == nil {
+		return message, nil
+	}
+	entry := newAuditEntry(command)
+	output, err := run(c, command.Args[1:], entry)
+	if err != nil {
+		entry.finish(nil, err)
+	}
+	record(entry)
+	if err != nil {
+		log.Printf("Failed to run %s: %v", c.Name, err)
+		return fmt.Sprintf("%s. %s", err, c.usage()), nil
+	}
+	return output, nil
+}
+
+func cmdV3(command *bot.Cmd) (bot.CmdResultV3, error) {
+	result := bot.CmdResultV3{
+		Channel: command.Channel,
+		Message: make(chan string),
+		Done:    make(chan bool, 1)}
+
+	reply := func(message string) {
+		go func() {
+			for _, line := range strings.Split(message, "\n") {
+				result.Message <- line
+			}
+			result.Done <- true
+		}()
+	}
+	if message, ok := builtin(command); ok {
+		reply(message)
+		return result, nil
+	}
+	c, message := lookup(command)
+	if c == nil {
+		reply(message)
+		return result, nil
+	}
+	if err := startJob(c, command, result); err != nil {
+		log.Printf("Failed to run %s: %v", c.Name, err)
+		reply(fmt.Sprintf("%s. %s", err, c.usage()))
+	}
+	return result, nil
 }
 
 func init() {
+	filename := os.Getenv(configEnv)
+	if filename == "" {
+		log.Printf("%s env variable is not set, cmd plugin is disabled", configEnv)
+		return
+	}
+	if err := loadConfig(filename); err != nil {
+		log.Printf("Failed to load cmd configuration, cmd plugin is disabled: %v", err)
+		return
+	}
+	if pluginConfig.AuditLog != "" {
+		audit = &auditLog{
+			file:     pluginConfig.AuditLog,
+			maxSize:  pluginConfig.AuditMaxSize,
+			maxFiles: pluginConfig.AuditMaxFiles,
+		}
+	}
+
 	bot.RegisterCommand(
 		"cmd",
-		"run cmd on system",
-		"pwd",
-		cmd)
+		"Runs a command declared in the configuration",
+		"uptime",
+		origin.Command("cmd", cmd))
 	bot.RegisterCommandV3(
 		"cmdv3",
-		"run cmd on system",
-		"pwd",
-		cmdV3)
+		"Runs a command declared in the configuration, sending output line by line",
+		"uptime",
+		origin.CommandV3("cmd", cmdV3))
 }
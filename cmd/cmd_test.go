@@ -0,0 +1,317 @@
+package cmd
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chat-bot/bot"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const testConfig = `{
+	"nicks": ["alice", "bob"],
+	"linesPerSecond": 1000,
+	"lineBurst": 100,
+	"commands": [
+		{
+			"name": "echo",
+			"argv": ["echo", "{message:text}"]
+		},
+		{
+			"name": "greet",
+			"argv": ["sh", "-c", "echo \"hello $0 from $GREETING in $(pwd)\"", "{name}"],
+			"dir": "/",
+			"env": ["GREETING=bot", "PATH", "NOT_WHITELISTED_SECRET"],
+			"channels": ["#ops"]
+		},
+		{
+			"name": "count",
+			"argv": ["seq", "{n:int}"],
+			"maxOutput": 10
+		},
+		{
+			"name": "sleep",
+			"argv": ["sleep", "{seconds:int}"],
+			"timeout": 1,
+			"maxRuntime": 1
+		},
+		{
+			"name": "fail",
+			"argv": ["ls", "/nonexistent"],
+			"nicks": ["alice"]
+		}
+	]
+}`
+
+func TestCmd(t *testing.T) {
+	Convey("Given configured commands", t, func() {
+		dir, _ := ioutil.TempDir("", "cmd")
+		Reset(func() {
+			os.RemoveAll(dir)
+		})
+		file := filepath.Join(dir, "config.json")
+		ioutil.WriteFile(file, []byte(testConfig), 0600)
+		So(loadConfig(file), ShouldBeNil)
+		run := func(nick, channel string, args ...string) string {
+			reply, err := cmd(&bot.Cmd{
+				Channel: channel,
+				User:    &bot.User{Nick: nick},
+				Args:    args,
+				RawArgs: strings.Join(args, " "),
+			})
+			So(err, ShouldBeNil)
+			return reply
+		}
+
+		Convey("Arguments are not interpreted by a shell", func() {
+			So(run("alice", "#go", "echo", "pwd;", "rm", "-rf", "~"), ShouldEqual, "pwd; rm -rf ~")
+		})
+
+		Convey("Available commands are listed without arguments", func() {
+			So(run("bob", "#go"), ShouldEqual, "Available commands: count, echo, sleep")
+			So(run("alice", "#ops"), ShouldEqual, "Available commands: count, echo, fail, greet, sleep")
+		})
+
+		Convey("Unknown commands are reported", func() {
+			So(run("bob", "#go", "rm"), ShouldStartWith, "Unknown command rm.")
+		})
+
+		Convey("Nicks and channels are checked", func() {
+			So(run("mallory", "#go", "echo", "hi"), ShouldEqual, "You are not allowed to run echo here")
+			So(run("alice", "#go", "greet", "bob"), ShouldEqual, "You are not allowed to run greet here")
+			So(run("bob", "#go", "fail"), ShouldEqual, "You are not allowed to run fail here")
+			reply, err := cmd(&bot.Cmd{Channel: "#go", Args: []string{"echo", "hi"}})
+			So(err, ShouldBeNil)
+			So(reply, ShouldEqual, "You are not allowed to run echo here")
+		})
+
+		Convey("Arguments are validated by type", func() {
+			So(run("alice", "#go", "count", "many"), ShouldEqual,
+				`invalid n "many", expecting int. Usage: !cmd count <n:int>`)
+			So(run("alice", "#go", "count"), ShouldEqual,
+				"missing n. Usage: !cmd count <n:int>")
+			So(run("alice", "#go", "count", "1", "2"), ShouldEqual,
+				"too many arguments. Usage: !cmd count <n:int>")
+			So(run("alice", "#go", "echo", "-n", "hi"), ShouldStartWith, "invalid message")
+		})
+
+		Convey("Working directory and environment are set", func() {
+			So(run("alice", "#ops", "greet", "bob"), ShouldEqual, "hello bob from bot in /")
+		})
+
+		Convey("Environment is not inherited", func() {
+			os.Setenv("NOT_WHITELISTED_SECRET", "")
+			defer os.Unsetenv("NOT_WHITELISTED_SECRET")
+			So(commands["greet"].environ(), ShouldNotContain, "HOME="+os.Getenv("HOME"))
+			So(commands["greet"].environ(), ShouldContain, "GREETING=bot")
+		})
+
+		Convey("Output is capped", func() {
+			So(run("alice", "#go", "count", "100"), ShouldEqual, "1\n2\n3\n4\n5\n"+truncatedNote)
+		})
+
+		Convey("Long running commands time out", func() {
+			So(run("alice", "#go", "sleep", "5"), ShouldEndWith, "(timed out after 1s)")
+		})
+
+		Convey("Exit status is reported", func() {
+			So(run("alice", "#go", "fail"), ShouldEndWith, "(exit status 2)")
+		})
+
+		Convey("Output is sent line by line", func() {
+			lines := collect(startV3("alice", "count", "3"))
+
+			So(lines, ShouldHaveLength, 4)
+			So(lines[0], ShouldStartWith, "Job ")
+			So(lines[0], ShouldContainSubstring, ": count 3 (!cmd kill ")
+			So(lines[1:], ShouldResemble, []string{"1", "2", "3"})
+		})
+
+		Convey("Streamed output is capped", func() {
+			lines := collect(startV3("alice", "count", "100"))
+
+			So(lines[1:], ShouldResemble, []string{"1", "2", "3", "4", "5", truncatedNote})
+		})
+
+		Convey("Jobs time out", func() {
+			lines := collect(startV3("alice", "sleep", "5"))
+
+			So(lines[len(lines)-1], ShouldEndWith, "timed out after 1s)")
+		})
+
+		Convey("Throttled output doesn't count against the runtime", func() {
+			pluginConfig.LinesPerSecond = 20
+			pluginConfig.LineBurst = 1
+			commands["count"].MaxOutput = 1000
+			commands["count"].MaxRuntime = 1
+
+			lines := collect(startV3("alice", "count", "30"))
+
+			So(lines, ShouldHaveLength, 31)
+			So(lines[30], ShouldEqual, "30")
+		})
+
+		Convey("Jobs can be listed and killed", func() {
+			So(run("alice", "#go", "jobs"), ShouldEqual, "No jobs are running in this channel")
+			result := startV3("bob", "sleep", "5")
+			first := <-result.Message
+			id := first[4:strings.Index(first, ":")]
+
+			So(run("alice", "#go", "jobs"), ShouldStartWith, id+": sleep 5 (started by bob")
+			So(run("alice", "#other", "jobs"), ShouldEqual, "No jobs are running in this channel")
+			So(run("alice", "#go", "kill", "999"), ShouldEqual, "No job 999 is running in this channel")
+			So(run("alice", "#go", "kill", id), ShouldEqual, "Killing job "+id)
+
+			lines := collect(result)
+			So(lines[len(lines)-1], ShouldEqual, "(job "+id+" killed)")
+			So(run("alice", "#go", "jobs"), ShouldEqual, "No jobs are running in this channel")
+		})
+
+		Convey("Bad arguments of jobs are reported", func() {
+			lines := collect(startV3("alice", "count", "many"))
+
+			So(lines, ShouldResemble, []string{`invalid n "many", expecting int. Usage: !cmd count <n:int>`})
+		})
+	})
+
+	Convey("Given an audit log", t, func() {
+		dir, _ := ioutil.TempDir("", "cmd")
+		file := filepath.Join(dir, "config.json")
+		ioutil.WriteFile(file, []byte(testConfig), 0600)
+		So(loadConfig(file), ShouldBeNil)
+		audit = &auditLog{file: filepath.Join(dir, "audit.log"), maxSize: 1 << 20, maxFiles: 2}
+		Reset(func() {
+			audit = nil
+			os.RemoveAll(dir)
+		})
+		run := func(nick string, args ...string) string {
+			reply, _ := cmd(&bot.Cmd{Channel: "#go", User: &bot.User{Nick: nick}, Args: args})
+			return reply
+		}
+
+		Convey("Executed and refused commands are recorded", func() {
+			run("alice", "echo", "hi")
+			run("alice", "fail")
+			run("bob", "fail")
+			collect(startV3("bob", "count", "3"))
+
+			entries, err := audit.recent(10)
+			So(err, ShouldBeNil)
+			So(entries, ShouldHaveLength, 4)
+			So(entries[3].Command, ShouldEqual, "echo hi")
+			So(entries[3].Argv, ShouldResemble, []string{"echo", "hi"})
+			So(entries[3].ExitCode, ShouldEqual, 0)
+			So(entries[3].Output, ShouldEqual, "hi\n")
+			So(entries[2].ExitCode, ShouldNotEqual, 0)
+			So(entries[2].Error, ShouldBeEmpty)
+			So(entries[1].Nick, ShouldEqual, "bob")
+			So(entries[1].Error, ShouldEqual, "not allowed")
+			So(entries[0].Output, ShouldEqual, "1\n2\n3\n")
+			So(entries[0].ExitCode, ShouldEqual, 0)
+		})
+
+		Convey("Jobs which time out are recorded", func() {
+			collect(startV3("alice", "sleep", "5"))
+
+			entries, _ := audit.recent(1)
+			So(entries[0].ExitCode, ShouldEqual, -1)
+			So(entries[0].Error, ShouldEqual, "signal: killed")
+		})
+
+		Convey("History shows newest entries first", func() {
+			run("alice", "echo", "one")
+			run("alice", "echo", "two")
+
+			So(run("alice", "history", "1"), ShouldEndWith, "alice in #go: echo two (exit 0, 0.0s)")
+			So(strings.Split(run("bob", "history"), "\n"), ShouldHaveLength, 2)
+			So(run("carol", "history"), ShouldEqual, "You are not allowed to see history")
+			So(run("alice", "history", "x"), ShouldEqual, historyUsage)
+		})
+
+		Convey("History can have its own nicks", func() {
+			pluginConfig.AuditNicks = []string{"carol"}
+
+			So(run("carol", "history"), ShouldEqual, "No commands were run yet")
+			So(run("alice", "history"), ShouldEqual, "You are not allowed to see history")
+		})
+
+		Convey("Log is rotated", func() {
+			audit.maxSize = 300
+			for i := 0; i < 10; i++ {
+				run("alice", "echo", "rotated")
+			}
+
+			_, err := os.Stat(audit.file + ".2")
+			So(err, ShouldBeNil)
+			_, err = os.Stat(audit.file + ".3")
+			So(os.IsNotExist(err), ShouldBeTrue)
+			entries, _ := audit.recent(10)
+			So(len(entries), ShouldBeBetweenOrEqual, 1, 2)
+		})
+	})
+
+	Convey("Given a line limiter", t, func() {
+		limiter := newLineLimiter(20, 2)
+		start := time.Now()
+
+		for i := 0; i < 4; i++ {
+			So(limiter.wait(context.Background()), ShouldBeTrue)
+		}
+
+		So(time.Since(start), ShouldBeGreaterThanOrEqualTo, 90*time.Millisecond)
+	})
+
+	Convey("Given invalid configuration", t, func() {
+		Convey("Reserved names are refused", func() {
+			dir, _ := ioutil.TempDir("", "cmd")
+			defer os.RemoveAll(dir)
+			file := filepath.Join(dir, "config.json")
+			ioutil.WriteFile(file, []byte(`{"commands": [{"name": "jobs", "argv": ["ls"]}]}`), 0600)
+
+			So(loadConfig(file), ShouldNotBeNil)
+		})
+
+		Convey("Placeholders in program name are refused", func() {
+			c := &commandConfig{Name: "x", Argv: []string{"{program}"}}
+			So(c.parse(), ShouldNotBeNil)
+		})
+
+		Convey("Unknown placeholder types are refused", func() {
+			c := &commandConfig{Name: "x", Argv: []string{"ls", "{dir:path}"}}
+			So(c.parse(), ShouldNotBeNil)
+		})
+
+		Convey("Text placeholder must be the last one", func() {
+			c := &commandConfig{Name: "x", Argv: []string{"echo", "{a:text}", "{b}"}}
+			So(c.parse(), ShouldNotBeNil)
+		})
+	})
+}
+
+func startV3(nick string, args ...string) bot.CmdResultV3 {
+	result, err := cmdV3(&bot.Cmd{
+		Channel: "#go",
+		User:    &bot.User{Nick: nick},
+		Args:    args,
+	})
+	So(err, ShouldBeNil)
+	return result
+}
+
+// collect returns messages of result until it is done
+func collect(result bot.CmdResultV3) []string {
+	var lines []string
+	for {
+		select {
+		case line := <-result.Message:
+			lines = append(lines, line)
+		case <-result.Done:
+			return lines
+		}
+	}
+}
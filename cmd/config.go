@@ -0,0 +1,261 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	configEnv        = "CMD_CONFIG_FILE"
+	defaultTimeout   = 30   // seconds
+	defaultMaxOutput = 4096 // bytes
+	textParam        = "text"
+	waitDelay        = time.Second
+)
+
+var (
+	placeholder = regexp.MustCompile(`\{(\w+)(?::(\w+))?\}`)
+	// paramTypes validate values of placeholders. None of them allows values
+	// starting with "-" so arguments can't be turned into options.
+	paramTypes = map[string]*regexp.Regexp{
+		"int":  regexp.MustCompile(`^[0-9]+$`),
+		"word": regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]*$`),
+		"host": regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?(\.[A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?)*$`),
+		// text takes all remaining arguments, so it must be the last one
+		textParam: regexp.MustCompile(`^[^-]`),
+	}
+)
+
+type param struct {
+	name string
+	kind string
+}
+
+// commandConfig declares a command which can be run by the plugin
+type commandConfig struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	Argv        []string `json:"argv"`                 // program and arguments, may contain {name:type} placeholders
+	Dir         string   `json:"dir,omitempty"`        // working directory
+	Env         []string `json:"env,omitempty"`        // names of variables passed from the bot or NAME=value
+	Timeout     int      `json:"timeout,omitempty"`    // seconds
+	MaxOutput   int      `json:"maxOutput,omitempty"`  // bytes
+	MaxRuntime  int      `json:"maxRuntime,omitempty"` // seconds, for jobs started by cmdv3
+	Nicks       []string `json:"nicks,omitempty"`      // overrides nicks allowed to run commands
+	Channels    []string `json:"channels,omitempty"`   // overrides channels commands can be run in
+	params      []param
+}
+
+// config of the plugin
+type config struct {
+	Nicks    []string        `json:"nicks,omitempty"`    // nicks allowed to run commands, anyone if not set
+	Channels []string        `json:"channels,omitempty"` // channels commands can be run in, any if not set
+	Commands []commandConfig `json:"commands"`
+	// LinesPerSecond and LineBurst limit lines of jobs sent to channels
+	LinesPerSecond float64 `json:"linesPerSecond,omitempty"`
+	LineBurst      int     `json:"lineBurst,omitempty"`
+	// AuditLog is path of JSON Lines file recording every run command
+	AuditLog      string   `json:"auditLog,omitempty"`
+	AuditMaxSize  int64    `json:"auditMaxSize,omitempty"`  // bytes before the log is rotated
+	AuditMaxFiles int      `json:"auditMaxFiles,omitempty"` // rotated files kept
+	AuditNicks    []string `json:"auditNicks,omitempty"`    // nicks allowed to see history, nicks if not set
+}
+
+var (
+	pluginConfig *config
+	commands     map[string]*commandConfig // name -> commandConfig map
+)
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// parse finds placeholders of the command and checks they are valid
+func (c *commandConfig) parse() error {
+	if len(c.Argv) == 0 {
+		return fmt.Errorf("command %s has no argv", c.Name)
+	}
+	if placeholder.MatchString(c.Argv[0]) {
+		return fmt.Errorf("command %s can't have placeholder in program name", c.Name)
+	}
+	c.params = nil
+	seen := make(map[string]bool)
+	for _, arg := range c.Argv[1:] {
+		for _, match := range placeholder.FindAllStringSubmatch(arg, -1) {
+			name, kind := match[1], match[2]
+			if kind == "" {
+				kind = "word"
+			}
+			if _, found := paramTypes[kind]; !found {
+				return fmt.Errorf("command %s has unknown type %s of %s", c.Name, kind, name)
+			}
+			if seen[name] {
+				continue
+			}
+			if len(c.params) > 0 && c.params[len(c.params)-1].kind == textParam {
+				return fmt.Errorf("command %s has %s placeholder which is not the last one",
+					c.Name, textParam)
+			}
+			seen[name] = true
+			c.params = append(c.params, param{name, kind})
+		}
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = defaultTimeout
+	}
+	if c.MaxOutput <= 0 {
+		c.MaxOutput = defaultMaxOutput
+	}
+	if c.MaxRuntime <= 0 {
+		c.MaxRuntime = defaultMaxRuntime
+	}
+	return nil
+}
+
+// usage returns example of running the command, e.g. "!cmd ping <host:host>"
+func (c *commandConfig) usage() string {
+	parts := []string{"!cmd", c.Name}
+	for _, p := range c.params {
+		parts = append(parts, fmt.Sprintf("<%s:%s>", p.name, p.kind))
+	}
+	return "Usage: " + strings.Join(parts, " ")
+}
+
+// bind returns argv with placeholders replaced by validated args
+func (c *commandConfig) bind(args []string) ([]string, error) {
+	values := make(map[string]string)
+	for i, p := range c.params {
+		if i >= len(args) {
+			return nil, fmt.Errorf("missing %s", p.name)
+		}
+		value := args[i]
+		if p.kind == textParam {
+			value = strings.Join(args[i:], " ")
+		}
+		if !paramTypes[p.kind].MatchString(value) {
+			return nil, fmt.Errorf("invalid %s %q, expecting %s", p.name, value, p.kind)
+		}
+		values[p.name] = value
+	}
+	last := len(c.params) - 1
+	if len(args) > len(c.params) && (last < 0 || c.params[last].kind != textParam) {
+		return nil, fmt.Errorf("too many arguments")
+	}
+
+	argv := make([]string, len(c.Argv))
+	for i, arg := range c.Argv {
+		argv[i] = placeholder.ReplaceAllStringFunc(arg, func(match string) string {
+			return values[placeholder.FindStringSubmatch(match)[1]]
+		})
+	}
+	return argv, nil
+}
+
+// environ returns environment of the command, nothing is inherited unless
+// whitelisted
+func (c *commandConfig) environ() []string {
+	env := []string{}
+	for _, name := range c.Env {
+		if strings.Contains(name, "=") {
+			env = append(env, name)
+		} else if value, found := os.LookupEnv(name); found {
+			env = append(env, name+"="+value)
+		}
+	}
+	return env
+}
+
+// allowed reports whether nick can run the command in channel
+func (c *commandConfig) allowed(nick, channel string) bool {
+	nicks, channels := c.Nicks, c.Channels
+	if nicks == nil && pluginConfig != nil {
+		nicks = pluginConfig.Nicks
+	}
+	if channels == nil && pluginConfig != nil {
+		channels = pluginConfig.Channels
+	}
+	return (len(nicks) == 0 || contains(nicks, nick)) &&
+		(len(channels) == 0 || contains(channels, channel))
+}
+
+func (c *commandConfig) timeout() time.Duration {
+	return time.Duration(c.Timeout) * time.Second
+}
+
+func (c *commandConfig) maxRuntime() time.Duration {
+	return time.Duration(c.MaxRuntime) * time.Second
+}
+
+func linesPerSecond() float64 {
+	if pluginConfig == nil || pluginConfig.LinesPerSecond <= 0 {
+		return defaultLinesPerSecond
+	}
+	return pluginConfig.LinesPerSecond
+}
+
+func lineBurst() int {
+	if pluginConfig == nil || pluginConfig.LineBurst <= 0 {
+		return defaultLineBurst
+	}
+	return pluginConfig.LineBurst
+}
+
+// command prepares the command to be run with args. No shell is involved,
+// args end up as separate arguments of the program.
+func (c *commandConfig) command(ctx context.Context, args []string) (*exec.Cmd, error) {
+	argv, err := c.bind(args)
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	cmd.Dir = c.Dir
+	cmd.Env = c.environ()
+	// don't wait for children which inherited output of a killed command
+	cmd.WaitDelay = waitDelay
+	return cmd, nil
+}
+
+func loadConfig(filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	loaded := &config{}
+	if err := json.NewDecoder(file).Decode(loaded); err != nil {
+		return err
+	}
+	if loaded.AuditMaxSize <= 0 {
+		loaded.AuditMaxSize = defaultAuditMaxSize
+	}
+	if loaded.AuditMaxFiles <= 0 {
+		loaded.AuditMaxFiles = defaultAuditMaxFiles
+	}
+	loadedCommands := make(map[string]*commandConfig)
+	for i := range loaded.Commands {
+		c := &loaded.Commands[i]
+		if c.Name == "" {
+			return fmt.Errorf("command without name found")
+		}
+		if c.Name == "jobs" || c.Name == "kill" || c.Name == "history" {
+			return fmt.Errorf("command name %s is reserved", c.Name)
+		}
+		if err := c.parse(); err != nil {
+			return err
+		}
+		loadedCommands[c.Name] = c
+	}
+	pluginConfig, commands = loaded, loadedCommands
+	return nil
+}
@@ -0,0 +1,339 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chat-bot/bot"
+)
+
+const (
+	defaultMaxRuntime     = 600 // seconds
+	defaultLinesPerSecond = 1
+	defaultLineBurst      = 5
+	maxLineLength         = 64 * 1024
+)
+
+// job is a command streaming its output to a channel
+type job struct {
+	id      int
+	command string // command line as typed, e.g. "ping example.com"
+	config  *commandConfig
+	nick    string
+	channel string
+	started time.Time
+	cancel  context.CancelFunc
+}
+
+// jobList keeps running jobs
+type jobList struct {
+	sync.Mutex
+	lastID int
+	jobs   map[int]*job
+}
+
+var jobs = &jobList{jobs: make(map[int]*job)}
+
+func (l *jobList) add(j *job) {
+	l.Lock()
+	defer l.Unlock()
+	l.lastID++
+	j.id = l.lastID
+	l.jobs[j.id] = j
+}
+
+func (l *jobList) remove(id int) {
+	l.Lock()
+	defer l.Unlock()
+	delete(l.jobs, id)
+}
+
+func (l *jobList) get(id int) (*job, bool) {
+	l.Lock()
+	defer l.Unlock()
+	j, found := l.jobs[id]
+	return j, found
+}
+
+// inChannel returns jobs started in channel ordered by id
+func (l *jobList) inChannel(channel string) []*job {
+	l.Lock()
+	defer l.Unlock()
+	var list []*job
+	for _, j := range l.jobs {
+		if j.channel == channel {
+			list = append(list, j)
+		}
+	}
+	sort.Slice(list, func(i, k int) bool { return list[i].id < list[k].id })
+	return list
+}
+
+// lineLimiter allows burst lines at once and then one line per interval
+type lineLimiter struct {
+	interval time.Duration
+	burst    float64
+	tokens   float64
+	last     time.Time
+}
+
+func newLineLimiter(linesPerSecond float64, burst int) *lineLimiter {
+	return &lineLimiter{
+		interval: time.Duration(float64(time.Second) / linesPerSecond),
+		burst:    float64(burst),
+		tokens:   float64(burst),
+		last:     time.Now(),
+	}
+}
+
+// wait blocks until next line can be sent, false means ctx was done first
+func (l *lineLimiter) wait(ctx context.Context) bool {
+	now := time.Now()
+	l.tokens += float64(now.Sub(l.last)) / float64(l.interval)
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	if l.tokens >= 1 {
+		l.tokens--
+		return true
+	}
+	timer := time.NewTimer(time.Duration((1 - l.tokens) * float64(l.interval)))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		l.tokens, l.last = 0, time.Now()
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// lineQueue keeps lines read from a job until they can be sent
+type lineQueue struct {
+	sync.Mutex
+	lines  []string
+	closed bool
+	ready  chan struct{} // signals a new line or closing
+}
+
+func newLineQueue() *lineQueue {
+	return &lineQueue{ready: make(chan struct{}, 1)}
+}
+
+func (q *lineQueue) notify() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (q *lineQueue) push(line string) {
+	q.Lock()
+	q.lines = append(q.lines, line)
+	q.Unlock()
+	q.notify()
+}
+
+// close marks the end of output, queued lines can still be popped
+func (q *lineQueue) close() {
+	q.Lock()
+	q.closed = true
+	q.Unlock()
+	q.notify()
+}
+
+// pop blocks until there is a line, false means the queue is closed and empty
+func (q *lineQueue) pop() (string, bool) {
+	for {
+		q.Lock()
+		if len(q.lines) > 0 {
+			line := q.lines[0]
+			q.lines = q.lines[1:]
+			q.Unlock()
+			return line, true
+		}
+		closed := q.closed
+		q.Unlock()
+		if closed {
+			return "", false
+		}
+		<-q.ready
+	}
+}
+
+// sendLines sends queued lines no faster than the line limit allows until the
+// queue is done or the job is killed. Output written before a timeout is
+// still sent.
+func sendLines(ctx context.Context, queue *lineQueue, messages chan<- string) {
+	killed, stop := context.WithCancel(context.Background())
+	defer stop()
+	go func() {
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.Canceled) {
+				stop()
+			}
+		case <-killed.Done():
+		}
+	}()
+	limiter := newLineLimiter(linesPerSecond(), lineBurst())
+	for {
+		line, ok := queue.pop()
+		if !ok || !limiter.wait(killed) {
+			return
+		}
+		messages <- line
+	}
+}
+
+func formatRuntime(d time.Duration) string {
+	return d.Round(time.Second).String()
+}
+
+func jobsMessage(channel string) string {
+	list := jobs.inChannel(channel)
+	if len(list) == 0 {
+		return "No jobs are running in this channel"
+	}
+	lines := make([]string, 0, len(list))
+	for _, j := range list {
+		lines = append(lines, fmt.Sprintf("%d: %s (started by %s %s ago)", j.id,
+			j.command, j.nick, formatRuntime(time.Since(j.started))))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func killJob(command *bot.Cmd) string {
+	if len(command.Args) != 2 {
+		return "Usage: !cmd kill <job id>"
+	}
+	id, err := strconv.Atoi(command.Args[1])
+	j, found := jobs.get(id)
+	if err != nil || !found || j.channel != command.Channel {
+		return fmt.Sprintf("No job %s is running in this channel", command.Args[1])
+	}
+	if j.nick != nick(command) && !j.config.allowed(nick(command), command.Channel) {
+		return fmt.Sprintf("You are not allowed to kill job %d", id)
+	}
+	j.cancel()
+	return fmt.Sprintf("Killing job %d", id)
+}
+
+// stream sends output of running command line by line, at most MaxOutput
+// bytes in total and no faster than the line limit allows. Output is read as
+// soon as it is written and lines waiting for the limit are queued, so the
+// command never blocks on output and the queue never holds more than
+// MaxOutput. Lines over the limit are read and thrown away. The run is
+// described in entry.
+func stream(ctx context.Context, j *job, cmd *exec.Cmd, output io.ReadCloser,
+	messages chan<- string, entry *auditEntry) []string {
+	go func() {
+		// unblocks reading when the job is killed or times out
+		<-ctx.Done()
+		output.Close()
+	}()
+	queue := newLineQueue()
+	sent := make(chan struct{})
+	go func() {
+		defer close(sent)
+		sendLines(ctx, queue, messages)
+	}()
+	scanner := bufio.NewScanner(output)
+	scanner.Buffer(make([]byte, 4096), maxLineLength)
+	written, truncated := 0, false
+	for scanner.Scan() {
+		line := scanner.Text()
+		if truncated || line == "" {
+			continue
+		}
+		if written+len(line)+1 > j.config.MaxOutput {
+			truncated = true
+			continue
+		}
+		written += len(line) + 1
+		entry.appendOutput(line + "\n")
+		queue.push(line)
+	}
+	// lines too long for the scanner
+	io.Copy(ioutil.Discard, output)
+	err := cmd.Wait()
+	entry.finish(cmd, err)
+	// sending may outlast the deadline of a command which finished in time
+	ctxErr := ctx.Err()
+	queue.close()
+	<-sent
+
+	var notes []string
+	if truncated {
+		notes = append(notes, truncatedNote)
+	}
+	var exitErr *exec.ExitError
+	switch {
+	case errors.Is(ctxErr, context.Canceled):
+		notes = append(notes, fmt.Sprintf("(job %d killed)", j.id))
+	case errors.Is(ctxErr, context.DeadlineExceeded):
+		notes = append(notes, fmt.Sprintf("(job %d timed out after %s)", j.id,
+			j.config.maxRuntime()))
+	case errors.As(err, &exitErr):
+		notes = append(notes, fmt.Sprintf("(%s)", exitErr))
+	}
+	return notes
+}
+
+// startJob runs the command in background, sending its output to result
+func startJob(c *commandConfig, command *bot.Cmd, result bot.CmdResultV3) error {
+	entry := newAuditEntry(command)
+	ctx, cancel := context.WithTimeout(context.Background(), c.maxRuntime())
+	fail := func(err error) error {
+		cancel()
+		entry.finish(nil, err)
+		record(entry)
+		return err
+	}
+	cmd, err := c.command(ctx, command.Args[1:])
+	if err != nil {
+		return fail(err)
+	}
+	entry.Argv = cmd.Args
+	output, err := cmd.StdoutPipe()
+	if err != nil {
+		return fail(err)
+	}
+	cmd.Stderr = cmd.Stdout
+	if err := cmd.Start(); err != nil {
+		return fail(err)
+	}
+
+	j := &job{
+		command: strings.Join(command.Args, " "),
+		config:  c,
+		nick:    nick(command),
+		channel: command.Channel,
+		started: time.Now(),
+		cancel:  cancel,
+	}
+	jobs.add(j)
+	go func() {
+		defer jobs.remove(j.id)
+		result.Message <- fmt.Sprintf("Job %d: %s (!cmd kill %d stops it)", j.id, j.command, j.id)
+		notes := stream(ctx, j, cmd, output, result.Message, entry)
+		cancel()
+		record(entry)
+		for _, note := range notes {
+			result.Message <- note
+		}
+		result.Done <- true
+	}()
+	return nil
+}
@@ -7,6 +7,7 @@ import (
 	"strings"
 
 	"github.com/go-chat-bot/bot"
+	"github.com/go-chat-bot/plugins/origin"
 )
 
 const (
@@ -44,5 +45,5 @@ func init() {
 		"crypto",
 		"Encrypts the input data from its hash value",
 		"md5|sha-1 enter here text to encrypt",
-		crypto)
+		origin.Command("crypto", crypto))
 }
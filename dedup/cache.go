@@ -0,0 +1,118 @@
+package dedup
+
+import (
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+// maxRecent is the number of messages per target compared for similarity
+const maxRecent = 100
+
+// recentMessage is a message remembered for similarity comparisons
+type recentMessage struct {
+	shingles shingleSet
+	expires  time.Time
+}
+
+// messageCache remembers hashes of recently sent messages until they expire.
+// Expired entries are ignored right away and removed by a single janitor.
+type messageCache struct {
+	sync.Mutex
+	entries map[uint64]time.Time       // hash -> expiry
+	recent  map[string][]recentMessage // target -> messages, oldest first
+}
+
+func newMessageCache() *messageCache {
+	return &messageCache{
+		entries: make(map[uint64]time.Time),
+		recent:  make(map[string][]recentMessage),
+	}
+}
+
+// messageHash returns hash of message sent to target. The separator makes
+// sure that moving characters between target and message changes the hash.
+func messageHash(msg, target string) uint64 {
+	hash := fnv.New64a()
+	hash.Write([]byte(target))
+	hash.Write([]byte{0})
+	hash.Write([]byte(msg))
+	return hash.Sum64()
+}
+
+// seen reports whether hash was recorded and has not expired yet. Unseen
+// hashes are recorded for ttl, so of concurrent calls with the same hash only
+// the first one returns false.
+func (c *messageCache) seen(hash uint64, now time.Time, ttl time.Duration) bool {
+	c.Lock()
+	defer c.Unlock()
+	if until, found := c.entries[hash]; found && now.Before(until) {
+		return true
+	}
+	c.entries[hash] = now.Add(ttl)
+	return false
+}
+
+// similar reports whether a message similar to one with given shingles was
+// sent to target and has not expired yet. Messages which are not similar to
+// any other are recorded for ttl.
+func (c *messageCache) similar(target string, set shingleSet, threshold float64,
+	now time.Time, ttl time.Duration) bool {
+	c.Lock()
+	defer c.Unlock()
+	for _, msg := range c.recent[target] {
+		if now.Before(msg.expires) && jaccard(set, msg.shingles) >= threshold {
+			return true
+		}
+	}
+	recent := append(c.recent[target], recentMessage{set, now.Add(ttl)})
+	if len(recent) > maxRecent {
+		recent = recent[len(recent)-maxRecent:]
+	}
+	c.recent[target] = recent
+	return false
+}
+
+// sweep removes entries expired at now
+func (c *messageCache) sweep(now time.Time) {
+	c.Lock()
+	defer c.Unlock()
+	for hash, until := range c.entries {
+		if !now.Before(until) {
+			delete(c.entries, hash)
+		}
+	}
+	for target, messages := range c.recent {
+		kept := messages[:0]
+		for _, msg := range messages {
+			if now.Before(msg.expires) {
+				kept = append(kept, msg)
+			}
+		}
+		if len(kept) == 0 {
+			delete(c.recent, target)
+		} else {
+			c.recent[target] = kept
+		}
+	}
+}
+
+func (c *messageCache) len() int {
+	c.Lock()
+	defer c.Unlock()
+	return len(c.entries)
+}
+
+// janitor sweeps expired entries every interval until stop is closed
+func (c *messageCache) janitor(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			c.sweep(now)
+		case <-stop:
+			return
+		}
+	}
+}
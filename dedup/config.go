@@ -0,0 +1,68 @@
+package dedup
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"time"
+)
+
+const targetConfigEnv = "DEDUP_CONFIG_FILE"
+
+// targetConfig overrides de-duplication settings of a single target
+type targetConfig struct {
+	Target  string `json:"target"`            // channel or user name
+	Timeout int    `json:"timeout,omitempty"` // minutes, DEDUP_TIMEOUT if not set
+	Exempt  bool   `json:"exempt,omitempty"`  // messages are never de-duplicated
+}
+
+var targetConfigs map[string]*targetConfig // target -> targetConfig map
+
+// window returns how long messages sent to target are remembered
+func window(target string) time.Duration {
+	if config, found := targetConfigs[target]; found && config.Timeout > 0 {
+		return time.Duration(config.Timeout) * time.Minute
+	}
+	return dedupConfig
+}
+
+func isExempt(target string) bool {
+	config, found := targetConfigs[target]
+	return found && config.Exempt
+}
+
+// shortestWindow returns the shortest configured window
+func shortestWindow() time.Duration {
+	shortest := dedupConfig
+	for target := range targetConfigs {
+		if w := window(target); w < shortest {
+			shortest = w
+		}
+	}
+	return shortest
+}
+
+func loadTargetConfigs(filename string) error {
+	targetConfigs = make(map[string]*targetConfig)
+
+	file, err := os.Open(filename)
+	if err != nil {
+		log.Printf("Failed opening configuration file %s: %v\n", filename, err)
+		return err
+	}
+	defer file.Close()
+	configs := make([]targetConfig, 0)
+	err = json.NewDecoder(file).Decode(&configs)
+	if err != nil {
+		log.Printf("Error loading configuration: %v\n", err)
+		return err
+	}
+	for i, config := range configs {
+		if config.Target == "" {
+			log.Println("Configuration without target found. Skipping")
+			continue
+		}
+		targetConfigs[config.Target] = &configs[i]
+	}
+	return nil
+}
@@ -2,64 +2,83 @@ package dedup
 
 import (
 	"github.com/go-chat-bot/bot"
-	"hash/fnv"
+	"github.com/go-chat-bot/plugins/origin"
 	"log"
 	"os"
 	"strconv"
-	"sync"
 	"time"
 )
 
 const (
 	dedupConfigEnv   = "DEDUP_TIMEOUT"
+	normalizeEnv     = "DEDUP_NORMALIZE"
+	similarityEnv    = "DEDUP_SIMILARITY"
+	reportEnv        = "DEDUP_REPORT_SUPPRESSED"
 	defaultDedupTime = "5"
+	// maxSweepInterval caps time between sweeps of expired messages
+	maxSweepInterval = time.Minute
 )
 
 var (
-	pastMessageMap     map[uint32]time.Time
-	pastMessageMapLock = sync.RWMutex{}
-	dedupConfig        time.Duration
-	fnvHash            = fnv.New32a()
+	pastMessages = newMessageCache()
+	dedupConfig  time.Duration
+	// normalize enables comparing messages without numbers and extra
+	// whitespace
+	normalize bool
+	// similarity is the minimal Jaccard index of shingles of two messages to
+	// consider them duplicates, 1 only drops identical messages
+	similarity = 1.0
+	// reportSuppressed enables telling channels how many duplicates were
+	// suppressed there
+	reportSuppressed bool
 )
 
-func messageHash(msg, target string) uint32 {
-	fnvHash.Write([]byte(msg))
-	fnvHash.Write([]byte(target))
-	defer fnvHash.Reset()
-	return fnvHash.Sum32()
+// sweepInterval returns how often the janitor removes expired messages
+func sweepInterval(ttl time.Duration) time.Duration {
+	if ttl <= 0 || ttl > maxSweepInterval {
+		return maxSweepInterval
+	}
+	return ttl
 }
 
-func recordMessage(msgHash uint32, target string) {
-	until := time.Now().UTC().Add(dedupConfig)
-	pastMessageMapLock.Lock()
-	pastMessageMap[msgHash] = until
-	pastMessageMapLock.Unlock()
-	go func() {
-		time.Sleep(dedupConfig)
-		pastMessageMapLock.Lock()
-		delete(pastMessageMap, msgHash)
-		pastMessageMapLock.Unlock()
-	}()
+// isDuplicate reports whether msg repeats a recent message sent to target
+// and records it otherwise
+func isDuplicate(msg, target string, now time.Time) bool {
+	if normalize {
+		msg = normalizeMessage(msg)
+	}
+	ttl := window(target)
+	if pastMessages.seen(messageHash(msg, target), now, ttl) {
+		return true
+	}
+	return similarity < 1 &&
+		pastMessages.similar(target, shingles(msg), similarity, now, ttl)
 }
 
 func dedupFilter(cmd *bot.FilterCmd) (string, error) {
-	msgHash := messageHash(cmd.Message, cmd.Target)
-	pastMessageMapLock.RLock()
-	_, found := pastMessageMap[msgHash]
-	pastMessageMapLock.RUnlock()
-	if !found {
-		// No past message like this, record and send
-		recordMessage(msgHash, cmd.Target)
+	if origin.Lookup(cmd) == "dedup" || isExempt(cmd.Target) {
+		return cmd.Message, nil
+	}
+	now := time.Now()
+	if !isDuplicate(cmd.Message, cmd.Target, now) {
+		// No past message like this, recorded and sent
+		if !reportSuppressed {
+			return cmd.Message, nil
+		}
+		if n := stats.takePending(cmd.Target); n > 0 {
+			message := cmd.Message + " " + suppressedNote(n)
+			return message, nil
+		}
 		return cmd.Message, nil
 	}
 
 	// Past message found, filter out!
+	stats.suppressed(cmd.Target, now.Add(window(cmd.Target)))
 	log.Printf("Deduplicating message in %s\n", cmd.Target)
 	return "", nil
 }
 
 func init() {
-	pastMessageMap = make(map[uint32]time.Time)
 	dedupVar := os.Getenv(dedupConfigEnv)
 	if dedupVar == "" {
 		dedupVar = defaultDedupTime
@@ -71,7 +90,49 @@ func init() {
 	}
 	dedupConfig = time.Duration(min) * time.Minute
 
+	if value := os.Getenv(normalizeEnv); value != "" {
+		normalize, err = strconv.ParseBool(value)
+		if err != nil {
+			log.Printf("Invalid %s value %q, not normalizing messages", normalizeEnv, value)
+		}
+	}
+	if value := os.Getenv(similarityEnv); value != "" {
+		threshold, err := strconv.ParseFloat(value, 64)
+		if err != nil || threshold <= 0 || threshold > 1 {
+			log.Printf("Invalid %s value %q, only identical messages are de-duplicated",
+				similarityEnv, value)
+		} else {
+			similarity = threshold
+		}
+	}
+	if value := os.Getenv(reportEnv); value != "" {
+		reportSuppressed, err = strconv.ParseBool(value)
+		if err != nil {
+			log.Printf("Invalid %s value %q, not reporting suppressed messages", reportEnv, value)
+		}
+	}
+	if filename := os.Getenv(targetConfigEnv); filename != "" {
+		if err := loadTargetConfigs(filename); err != nil {
+			log.Printf("Failed to load dedup target configuration, using %s for all targets",
+				dedupConfigEnv)
+		}
+	}
+	go pastMessages.janitor(sweepInterval(shortestWindow()), nil)
+
 	bot.RegisterFilterCommand(
 		"dedup",
 		dedupFilter)
+	bot.RegisterCommandV3(
+		"dedup",
+		"Shows how many duplicate messages were suppressed in each channel",
+		"stats",
+		origin.CommandV3("dedup", dedup))
+	if reportSuppressed {
+		bot.RegisterPeriodicCommandV2(
+			"dedupReportSuppressed",
+			origin.Periodic("dedup", bot.PeriodicConfig{
+				CronSpec:  "@every 1m",
+				CmdFuncV2: reportExpired,
+			}))
+	}
 }
@@ -0,0 +1,248 @@
+package dedup
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-chat-bot/bot"
+	"github.com/go-chat-bot/plugins/origin"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDedup(t *testing.T) {
+	Convey("Given a message hash", t, func() {
+		Convey("Target and message are separated", func() {
+			So(messageHash("c", "ab"), ShouldNotEqual, messageHash("bc", "a"))
+		})
+
+		Convey("Same message and target give the same hash", func() {
+			So(messageHash("hello", "#go"), ShouldEqual, messageHash("hello", "#go"))
+		})
+	})
+
+	Convey("Given a message cache", t, func() {
+		cache := newMessageCache()
+		now := time.Now()
+
+		Convey("Messages are seen until they expire", func() {
+			So(cache.seen(1, now, time.Minute), ShouldBeFalse)
+			So(cache.seen(1, now.Add(30*time.Second), time.Minute), ShouldBeTrue)
+			So(cache.seen(1, now.Add(time.Minute), time.Minute), ShouldBeFalse)
+		})
+
+		Convey("Expired messages are swept", func() {
+			cache.seen(1, now, time.Minute)
+			cache.seen(2, now.Add(30*time.Second), time.Minute)
+			cache.similar("#go", shingles("hello"), 0.5, now, time.Minute)
+
+			cache.sweep(now.Add(time.Minute))
+
+			So(cache.len(), ShouldEqual, 1)
+			So(cache.recent, ShouldBeEmpty)
+		})
+
+		Convey("Janitor stops when asked to", func() {
+			stop := make(chan struct{})
+			stopped := make(chan struct{})
+			go func() {
+				cache.janitor(time.Millisecond, stop)
+				close(stopped)
+			}()
+
+			close(stop)
+
+			var done bool
+			select {
+			case <-stopped:
+				done = true
+			case <-time.After(time.Second):
+			}
+			So(done, ShouldBeTrue)
+		})
+	})
+
+	Convey("Given messages to compare", t, func() {
+		Convey("Numbers, timestamps and extra whitespace are removed", func() {
+			So(normalizeMessage("Build  #42 failed at 2019-01-02 12:30:05 "),
+				ShouldEqual, "Build # failed at")
+		})
+
+		Convey("Identical messages are fully similar", func() {
+			So(jaccard(shingles("hello world"), shingles("Hello world")), ShouldEqual, 1)
+		})
+
+		Convey("Different messages are not similar", func() {
+			So(jaccard(shingles("hello world"), shingles("xyz")), ShouldEqual, 0)
+		})
+
+		Convey("Near duplicates are similar", func() {
+			So(jaccard(shingles("Chuck Norris can divide by zero."),
+				shingles("Chuck Norris can divide by zero!")), ShouldBeGreaterThan, 0.9)
+		})
+	})
+
+	Convey("Given the dedup filter", t, func() {
+		pastMessages = newMessageCache()
+		dedupConfig = 5 * time.Minute
+		Reset(func() {
+			normalize = false
+			similarity = 1
+			targetConfigs = nil
+			reportSuppressed = false
+			stats = newSuppressionStats()
+			origin.Clear()
+		})
+		cmd := &bot.FilterCmd{Target: "#go", Message: "hello"}
+
+		Convey("Repeated message is filtered out", func() {
+			first, _ := dedupFilter(cmd)
+			second, _ := dedupFilter(cmd)
+
+			So(first, ShouldEqual, "hello")
+			So(second, ShouldEqual, "")
+		})
+
+		Convey("Same message is sent to other targets", func() {
+			dedupFilter(cmd)
+
+			message, _ := dedupFilter(&bot.FilterCmd{Target: "#other", Message: "hello"})
+
+			So(message, ShouldEqual, "hello")
+		})
+
+		Convey("Messages differing in timestamps are filtered out when normalizing", func() {
+			normalize = true
+			dedupFilter(&bot.FilterCmd{Target: "#go", Message: "Outage since 12:30"})
+
+			message, _ := dedupFilter(&bot.FilterCmd{Target: "#go", Message: "Outage since  12:31"})
+
+			So(message, ShouldEqual, "")
+		})
+
+		Convey("Similar messages are filtered out above threshold", func() {
+			similarity = 0.8
+			dedupFilter(&bot.FilterCmd{Target: "#go", Message: "Chuck Norris can divide by zero."})
+
+			similar, _ := dedupFilter(&bot.FilterCmd{Target: "#go", Message: "Chuck Norris can divide by zero!"})
+			different, _ := dedupFilter(&bot.FilterCmd{Target: "#go", Message: "Chuck Norris counted to infinity."})
+
+			So(similar, ShouldEqual, "")
+			So(different, ShouldEqual, "Chuck Norris counted to infinity.")
+		})
+
+		Convey("Targets can have own windows and be exempt", func() {
+			dir, _ := ioutil.TempDir("", "dedup")
+			defer os.RemoveAll(dir)
+			file := filepath.Join(dir, "config.json")
+			ioutil.WriteFile(file, []byte(`[
+				{"target": "#ops", "timeout": 30},
+				{"target": "#random", "exempt": true}
+			]`), 0600)
+
+			So(loadTargetConfigs(file), ShouldBeNil)
+			So(window("#ops"), ShouldEqual, 30*time.Minute)
+			So(window("#go"), ShouldEqual, 5*time.Minute)
+			So(shortestWindow(), ShouldEqual, 5*time.Minute)
+
+			dedupFilter(&bot.FilterCmd{Target: "#random", Message: "hello"})
+			message, _ := dedupFilter(&bot.FilterCmd{Target: "#random", Message: "hello"})
+
+			So(message, ShouldEqual, "hello")
+		})
+
+		Convey("Suppressed duplicates are counted", func() {
+			dedupFilter(cmd)
+			dedupFilter(cmd)
+			dedupFilter(cmd)
+
+			So(stats.totals(), ShouldResemble, map[string]int{"#go": 2})
+			So(statsLines(), ShouldResemble, []string{"#go: 2 suppressed"})
+		})
+
+		Convey("Suppressed duplicates are reported with the next message", func() {
+			reportSuppressed = true
+			dedupFilter(cmd)
+			dedupFilter(cmd)
+			dedupFilter(cmd)
+
+			message, _ := dedupFilter(&bot.FilterCmd{Target: "#go", Message: "bye"})
+			next, _ := dedupFilter(&bot.FilterCmd{Target: "#go", Message: "hi"})
+
+			So(message, ShouldEqual, "bye (suppressed 2 duplicate messages)")
+			So(next, ShouldEqual, "hi")
+		})
+
+		Convey("Suppressed duplicates are reported when the window ends", func() {
+			stats.suppressed("#go", time.Now().Add(-time.Second))
+			stats.suppressed("#ops", time.Now().Add(time.Minute))
+
+			results, err := reportExpired()
+
+			So(err, ShouldBeNil)
+			So(results, ShouldResemble, []bot.CmdResult{
+				{Channel: "#go", Message: "(suppressed 1 duplicate message)"}})
+			So(stats.takePending("#go"), ShouldEqual, 0)
+			So(stats.takePending("#ops"), ShouldEqual, 1)
+		})
+
+		Convey("Messages of the plugin itself are never suppressed", func() {
+			origin.Record("dedup", "#go", "hello")
+			dedupFilter(cmd)
+
+			message, _ := dedupFilter(cmd)
+
+			So(message, ShouldEqual, "hello")
+		})
+
+		Convey("Stats command shows usage without arguments", func() {
+			So(statsLines(), ShouldResemble, []string{"No duplicate messages were suppressed"})
+
+			result, err := dedup(&bot.Cmd{Channel: "#go"})
+
+			So(err, ShouldBeNil)
+			So(<-result.Message, ShouldEqual, statsUsage)
+		})
+
+		Convey("Only one of concurrent duplicates is sent", func() {
+			var sent int32
+			var wg sync.WaitGroup
+			for i := 0; i < 50; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					message, _ := dedupFilter(&bot.FilterCmd{Target: "#go", Message: "hello"})
+					if message != "" {
+						atomic.AddInt32(&sent, 1)
+					}
+				}()
+			}
+			wg.Wait()
+
+			So(sent, ShouldEqual, 1)
+		})
+	})
+}
+
+func BenchmarkDedupFilterParallel(b *testing.B) {
+	pastMessages = newMessageCache()
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+	var counter int64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			n := atomic.AddInt64(&counter, 1)
+			dedupFilter(&bot.FilterCmd{
+				Target:  fmt.Sprintf("#channel%d", n%16),
+				Message: fmt.Sprintf("message %d", n%1024),
+			})
+		}
+	})
+}
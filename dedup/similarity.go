@@ -0,0 +1,55 @@
+package dedup
+
+import (
+	"regexp"
+	"strings"
+)
+
+const shingleSize = 3 // characters
+
+var (
+	// numbers matches numbers together with separators used in dates,
+	// times and versions, e.g. 2019-01-02, 12:30:05 or 1.2.3
+	numbers = regexp.MustCompile(`[0-9]+([:./-][0-9]+)*`)
+)
+
+type shingleSet map[string]struct{}
+
+// normalizeMessage removes numbers including timestamps and collapses
+// whitespace so that messages differing only in those compare equal
+func normalizeMessage(msg string) string {
+	return strings.Join(strings.Fields(numbers.ReplaceAllString(msg, "")), " ")
+}
+
+// shingles returns set of all substrings of shingleSize characters of the
+// lower cased message
+func shingles(msg string) shingleSet {
+	runes := []rune(strings.ToLower(msg))
+	set := make(shingleSet)
+	if len(runes) <= shingleSize {
+		set[string(runes)] = struct{}{}
+		return set
+	}
+	for i := 0; i+shingleSize <= len(runes); i++ {
+		set[string(runes[i:i+shingleSize])] = struct{}{}
+	}
+	return set
+}
+
+// jaccard returns size of intersection divided by size of union of the sets,
+// 1 for identical sets and 0 for sets without anything in common
+func jaccard(a, b shingleSet) float64 {
+	if len(a) == 0 && len(b) == 0 {
+		return 1
+	}
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+	common := 0
+	for shingle := range a {
+		if _, found := b[shingle]; found {
+			common++
+		}
+	}
+	return float64(common) / float64(len(a)+len(b)-common)
+}
@@ -0,0 +1,143 @@
+package dedup
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-chat-bot/bot"
+)
+
+const statsUsage = "Usage: !dedup stats"
+
+// suppressedCount counts duplicates suppressed in a target
+type suppressedCount struct {
+	total   int
+	pending int       // suppressed since the last report
+	until   time.Time // when pending count is reported if the bot stays quiet
+}
+
+// suppressionStats keeps counts of suppressed duplicates per target
+type suppressionStats struct {
+	sync.Mutex
+	targets map[string]*suppressedCount
+}
+
+var stats = newSuppressionStats()
+
+func newSuppressionStats() *suppressionStats {
+	return &suppressionStats{targets: make(map[string]*suppressedCount)}
+}
+
+// suppressed counts a duplicate suppressed in target whose window ends at
+// until
+func (s *suppressionStats) suppressed(target string, until time.Time) {
+	s.Lock()
+	defer s.Unlock()
+	count, found := s.targets[target]
+	if !found {
+		count = &suppressedCount{}
+		s.targets[target] = count
+	}
+	count.total++
+	count.pending++
+	count.until = until
+}
+
+// takePending returns number of duplicates suppressed in target since the
+// last report and resets it
+func (s *suppressionStats) takePending(target string) int {
+	s.Lock()
+	defer s.Unlock()
+	count, found := s.targets[target]
+	if !found {
+		return 0
+	}
+	pending := count.pending
+	count.pending = 0
+	return pending
+}
+
+// takeExpired returns pending counts of targets whose window ended before
+// now and resets them
+func (s *suppressionStats) takeExpired(now time.Time) map[string]int {
+	s.Lock()
+	defer s.Unlock()
+	expired := make(map[string]int)
+	for target, count := range s.targets {
+		if count.pending > 0 && !now.Before(count.until) {
+			expired[target] = count.pending
+			count.pending = 0
+		}
+	}
+	return expired
+}
+
+// totals returns number of duplicates suppressed in each target
+func (s *suppressionStats) totals() map[string]int {
+	s.Lock()
+	defer s.Unlock()
+	totals := make(map[string]int, len(s.targets))
+	for target, count := range s.targets {
+		totals[target] = count.total
+	}
+	return totals
+}
+
+func suppressedNote(n int) string {
+	if n == 1 {
+		return "(suppressed 1 duplicate message)"
+	}
+	return fmt.Sprintf("(suppressed %d duplicate messages)", n)
+}
+
+// reportExpired tells targets about duplicates suppressed in windows which
+// have ended without the bot saying anything else there
+func reportExpired() ([]bot.CmdResult, error) {
+	var results []bot.CmdResult
+	for target, n := range stats.takeExpired(time.Now()) {
+		results = append(results, bot.CmdResult{
+			Channel: target,
+			Message: suppressedNote(n),
+		})
+	}
+	return results, nil
+}
+
+// statsLines returns lines listing suppressed duplicates per target
+func statsLines() []string {
+	totals := stats.totals()
+	if len(totals) == 0 {
+		return []string{"No duplicate messages were suppressed"}
+	}
+	targets := make([]string, 0, len(totals))
+	for target := range totals {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+	lines := make([]string, 0, len(targets))
+	for _, target := range targets {
+		lines = append(lines, fmt.Sprintf("%s: %d suppressed", target, totals[target]))
+	}
+	return lines
+}
+
+func dedup(cmd *bot.Cmd) (bot.CmdResultV3, error) {
+	result := bot.CmdResultV3{
+		Channel: cmd.Channel,
+		Message: make(chan string),
+		Done:    make(chan bool, 1)}
+
+	lines := []string{statsUsage}
+	if len(cmd.Args) == 1 && cmd.Args[0] == "stats" {
+		lines = statsLines()
+	}
+	go func() {
+		for _, line := range lines {
+			result.Message <- line
+		}
+		result.Done <- true
+	}()
+	return result, nil
+}
@@ -6,6 +6,7 @@ import (
 	"strings"
 
 	"github.com/go-chat-bot/bot"
+	"github.com/go-chat-bot/plugins/origin"
 )
 
 func decode(command *bot.Cmd) (string, error) {
@@ -44,5 +45,5 @@ func init() {
 		"decode",
 		"Decodes the given string",
 		"base64 VGhlIEdvIFByb2dyYW1taW5nIExhbmd1YWdl",
-		decode)
+		origin.Command("encoding", decode))
 }
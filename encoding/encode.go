@@ -6,6 +6,7 @@ import (
 	"strings"
 
 	"github.com/go-chat-bot/bot"
+	"github.com/go-chat-bot/plugins/origin"
 )
 
 const (
@@ -45,5 +46,5 @@ func init() {
 		"encode",
 		"Allows you encoding a value",
 		"base64 enter here text to encode",
-		encode)
+		origin.Command("encoding", encode))
 }
@@ -0,0 +1,117 @@
+package gif
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"strings"
+)
+
+const (
+	channelConfigEnv = "GIF_CONFIG_FILE"
+	ratingEnv        = "GIF_RATING"
+	renditionEnv     = "GIF_RENDITION"
+	defaultRating    = "g"
+
+	renditionFixedHeight = "fixed_height"
+	renditionOriginal    = "original"
+	renditionDownsampled = "downsampled"
+	renditionStill       = "still"
+	renditionMp4         = "mp4"
+)
+
+var (
+	// ratings from the most to the least restrictive
+	ratings    = []string{"g", "pg", "pg-13", "r"}
+	renditions = []string{renditionFixedHeight, renditionOriginal, renditionDownsampled,
+		renditionStill, renditionMp4}
+)
+
+// channelConfig sets what gifs are posted in a channel
+type channelConfig struct {
+	Channel string `json:"channel"`          // channel name or * for all channels without own config
+	Rating  string `json:"rating,omitempty"` // the highest content rating (g, pg, pg-13 or r)
+	// Rendition of gifs to post: fixed_height, original, downsampled, still or mp4
+	Rendition string `json:"rendition,omitempty"`
+}
+
+var (
+	channelConfigs map[string]*channelConfig // channel -> channelConfig map
+	rating         = defaultRating           // for channels without rating
+	rendition      = renditionFixedHeight    // for channels without rendition
+)
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+func validRating(value string) bool {
+	return contains(ratings, value)
+}
+
+func validRendition(value string) bool {
+	return contains(renditions, value)
+}
+
+func getChannelConfig(channel string) *channelConfig {
+	if config, found := channelConfigs[channel]; found {
+		return config
+	}
+	return channelConfigs["*"]
+}
+
+// ratingFor returns the highest content rating of gifs posted in channel
+func ratingFor(channel string) string {
+	if config := getChannelConfig(channel); config != nil && config.Rating != "" {
+		return config.Rating
+	}
+	return rating
+}
+
+// renditionFor returns rendition of gifs posted in channel
+func renditionFor(channel string) string {
+	if config := getChannelConfig(channel); config != nil && config.Rendition != "" {
+		return config.Rendition
+	}
+	return rendition
+}
+
+func loadChannelConfigs(filename string) error {
+	channelConfigs = make(map[string]*channelConfig)
+
+	file, err := os.Open(filename)
+	if err != nil {
+		log.Printf("Failed opening configuration file %s: %v\n", filename, err)
+		return err
+	}
+	defer file.Close()
+	configs := make([]channelConfig, 0)
+	err = json.NewDecoder(file).Decode(&configs)
+	if err != nil {
+		log.Printf("Error loading configuration: %v\n", err)
+		return err
+	}
+	for i, config := range configs {
+		if config.Channel == "" {
+			log.Println("Configuration without channel found. Skipping")
+			continue
+		}
+		configs[i].Rating = strings.ToLower(config.Rating)
+		if config.Rating != "" && !validRating(configs[i].Rating) {
+			log.Printf("Invalid rating %q of %s. Skipping", config.Rating, config.Channel)
+			continue
+		}
+		configs[i].Rendition = strings.ToLower(config.Rendition)
+		if config.Rendition != "" && !validRendition(configs[i].Rendition) {
+			log.Printf("Invalid rendition %q of %s. Skipping", config.Rendition, config.Channel)
+			continue
+		}
+		channelConfigs[config.Channel] = &configs[i]
+	}
+	return nil
+}
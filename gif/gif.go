@@ -2,99 +2,209 @@ package gif
 
 import (
 	"fmt"
-	"github.com/go-chat-bot/bot"
-	"github.com/go-chat-bot/plugins/web"
+	"log"
 	"math/rand"
-	"net/url"
+	"os"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/go-chat-bot/bot"
+	"github.com/go-chat-bot/plugins/origin"
+	"github.com/go-chat-bot/plugins/web"
 )
 
 const (
-	giphyURL = "http://api.giphy.com/v1/gifs/search?q=%s&api_key=dc6zaTOxFJmzC&limit=50"
+	providerEnv    = "GIF_PROVIDER"
+	giphyKeyEnv    = "GIPHY_API_KEY"
+	tenorKeyEnv    = "TENOR_API_KEY"
+	searchLimit    = 50 // gifs in a page of results
+	maxPages       = 3  // pages searched for gifs which were not posted recently
+	pageCacheTTL   = 10 * time.Minute
+	maxCount       = 5 // gifs posted at once
+	apiPerSecond   = 1 // requests to the gif APIs, after a burst of apiBurst
+	apiBurst       = 5
+	trendingQuery  = "trending"
+	usage          = "Usage: !gif [-n count] <search terms> or !gif [-n count] trending"
+	noGifsFound    = "No gifs found. try: !gif cat"
+	notAvailableOf = "%s is not available right now, try again later."
 )
 
-type giphy struct {
-	Data []struct {
-		BitlyURL string `json:"bitly_url"`
-		Images   struct {
-			FixedHeight struct {
-				Height string `json:"height"`
-				URL    string `json:"url"`
-				Width  string `json:"width"`
-			} `json:"fixed_height"`
-			FixedHeightDownsampled struct {
-				Height string `json:"height"`
-				URL    string `json:"url"`
-				Width  string `json:"width"`
-			} `json:"fixed_height_downsampled"`
-			FixedHeightStill struct {
-				Height string `json:"height"`
-				URL    string `json:"url"`
-				Width  string `json:"width"`
-			} `json:"fixed_height_still"`
-			FixedWidth struct {
-				Height string `json:"height"`
-				URL    string `json:"url"`
-				Width  string `json:"width"`
-			} `json:"fixed_width"`
-			FixedWidthDownsampled struct {
-				Height string `json:"height"`
-				URL    string `json:"url"`
-				Width  string `json:"width"`
-			} `json:"fixed_width_downsampled"`
-			FixedWidthStill struct {
-				Height string `json:"height"`
-				URL    string `json:"url"`
-				Width  string `json:"width"`
-			} `json:"fixed_width_still"`
-			Original struct {
-				Frames string `json:"frames"`
-				Height string `json:"height"`
-				Size   string `json:"size"`
-				URL    string `json:"url"`
-				Width  string `json:"width"`
-			} `json:"original"`
-		} `json:"images"`
-		Type        string `json:"type"`
-		Username    string `json:"username"`
-		BitlyGifURL string `json:"bitly_gif_url"`
-		EmbedURL    string `json:"embed_url"`
-		ID          string `json:"id"`
-		Rating      string `json:"rating"`
-		Source      string `json:"source"`
-		URL         string `json:"url"`
-	} `json:"data"`
-	Meta struct {
-		Msg    string `json:"msg"`
-		Status int64  `json:"status"`
-	} `json:"meta"`
-	Pagination struct {
-		Count      int64 `json:"count"`
-		Offset     int64 `json:"offset"`
-		TotalCount int64 `json:"total_count"`
-	} `json:"pagination"`
+// gifResult is a gif found by a provider
+type gifResult struct {
+	ID   string
+	URLs map[string]string // rendition -> URL
+}
+
+// url returns URL of rendition, the default one if the gif doesn't have it
+func (r gifResult) url(rendition string) string {
+	if url := r.URLs[rendition]; url != "" {
+		return url
+	}
+	return r.URLs[renditionFixedHeight]
+}
+
+// provider searches gifs
+type provider interface {
+	name() string
+	// search returns up to limit gifs matching query with content rating
+	// up to rating, trending gifs if query is empty. page is empty for the
+	// first page, otherwise next returned with the previous page. next is
+	// empty after the last page.
+	search(query, rating string, limit int, page string) (results []gifResult, next string, err error)
+}
+
+var backend provider
+
+// parseArgs reads optional "-n count" followed by search terms
+func parseArgs(args []string) (count int, query string, ok bool) {
+	count = 1
+	if len(args) >= 2 && args[0] == "-n" {
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 1 || n > maxCount {
+			return 0, "", false
+		}
+		count, args = n, args[2:]
+	}
+	query = strings.Join(args, " ")
+	return count, query, query != ""
 }
 
 func gif(command *bot.Cmd) (msg string, err error) {
-	data := &giphy{}
-	err = web.GetJSON(fmt.Sprintf(giphyURL, url.QueryEscape(command.RawArgs)), data)
+	count, query, ok := parseArgs(command.Args)
+	if !ok {
+		return usage, nil
+	}
+	if query == trendingQuery {
+		query = ""
+	}
+
+	results, fresh, err := searchFresh(command.Channel, query, count)
+	if web.IsUnavailable(err) {
+		return fmt.Sprintf(notAvailableOf, backend.name()), nil
+	}
 	if err != nil {
 		return "", err
 	}
+	if len(results) == 0 {
+		return noGifsFound, nil
+	}
 
-	if len(data.Data) == 0 {
-		return "No gifs found. try: !gif cat", nil
+	// gifs which were not posted recently go first, repeats only fill in
+	var chosen []gifResult
+	for _, pool := range [][]gifResult{fresh, results} {
+		for _, index := range rand.Perm(len(pool)) {
+			if len(chosen) < count && !containsGif(chosen, pool[index].ID) {
+				chosen = append(chosen, pool[index])
+			}
+		}
+	}
+	channelRendition := renditionFor(command.Channel)
+	urls := make([]string, 0, len(chosen))
+	ids := make([]string, 0, len(chosen))
+	for _, gif := range chosen {
+		urls = append(urls, gif.url(channelRendition))
+		ids = append(ids, gif.ID)
 	}
+	recent.add(command.Channel, ids...)
+	return strings.Join(urls, "\n"), nil
+}
 
-	index := rand.Intn(len(data.Data))
-	return fmt.Sprintf(data.Data[index].Images.FixedHeight.URL), nil
+func containsGif(gifs []gifResult, id string) bool {
+	for _, gif := range gifs {
+		if gif.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// searchFresh returns gifs found for query and those of them which were not
+// posted to channel recently. Following pages are searched until there are
+// count fresh gifs or maxPages were searched.
+func searchFresh(channel, query string, count int) (results, fresh []gifResult, err error) {
+	page := ""
+	for i := 0; i < maxPages; i++ {
+		found, next, err := backend.search(query, ratingFor(channel), searchLimit, page)
+		if err != nil {
+			if i == 0 {
+				return nil, nil, err
+			}
+			log.Printf("Failed to get next page of gifs: %v", err)
+			break
+		}
+		for _, gif := range found {
+			if containsGif(results, gif.ID) {
+				continue
+			}
+			results = append(results, gif)
+			if !recent.seen(channel, gif.ID) {
+				fresh = append(fresh, gif)
+			}
+		}
+		if len(fresh) >= count || next == "" {
+			break
+		}
+		page = next
+	}
+	return results, fresh, nil
+}
+
+// newProvider returns provider chosen by name, which needs its API key set
+func newProvider(name string) (provider, error) {
+	switch strings.ToLower(name) {
+	case "", "giphy":
+		key := os.Getenv(giphyKeyEnv)
+		if key == "" {
+			return nil, fmt.Errorf("%s env variable is not set", giphyKeyEnv)
+		}
+		return &giphyProvider{apiKey: key}, nil
+	case "tenor":
+		key := os.Getenv(tenorKeyEnv)
+		if key == "" {
+			return nil, fmt.Errorf("%s env variable is not set", tenorKeyEnv)
+		}
+		return &tenorProvider{apiKey: key}, nil
+	}
+	return nil, fmt.Errorf("unknown gif provider %s", name)
 }
 
 func init() {
 	rand.Seed(time.Now().UnixNano())
+
+	var err error
+	backend, err = newProvider(os.Getenv(providerEnv))
+	if err != nil {
+		log.Printf("Failed to set up gif provider, gif plugin is disabled: %v", err)
+		return
+	}
+	limit := web.RateLimit{PerSecond: apiPerSecond, Burst: apiBurst}
+	web.SetHostRateLimit(giphyHost, limit)
+	web.SetHostRateLimit(tenorHost, limit)
+	if value := strings.ToLower(os.Getenv(ratingEnv)); value != "" {
+		if validRating(value) {
+			rating = value
+		} else {
+			log.Printf("Invalid %s value %q, using %s", ratingEnv, value, defaultRating)
+		}
+	}
+	if value := strings.ToLower(os.Getenv(renditionEnv)); value != "" {
+		if validRendition(value) {
+			rendition = value
+		} else {
+			log.Printf("Invalid %s value %q, using %s", renditionEnv, value, renditionFixedHeight)
+		}
+	}
+	if confFile := os.Getenv(channelConfigEnv); confFile != "" {
+		err := loadChannelConfigs(confFile)
+		if err != nil {
+			log.Printf("Error loading channel configuration (non-fatal): %v\n", err)
+		}
+	}
+
 	bot.RegisterCommand(
 		"gif",
-		"Searchs and posts a random gif url from Giphy.",
+		"Searchs and posts a random gif url from Giphy or Tenor.",
 		"cat",
-		gif)
+		origin.Command("gif", gif))
 }
@@ -0,0 +1,127 @@
+package gif
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-chat-bot/bot"
+	"github.com/go-chat-bot/plugins/web"
+	"github.com/go-chat-bot/plugins/web/webtest"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestGif(t *testing.T) {
+	run := func(channel string, args ...string) string {
+		msg, err := gif(&bot.Cmd{Channel: channel, Args: args, RawArgs: strings.Join(args, " ")})
+		So(err, ShouldBeNil)
+		return msg
+	}
+
+	Convey("Given Giphy", t, func() {
+		webtest.Use(t, "giphy").IgnoreQuery("api_key")
+		web.DefaultCache.Clear()
+		backend = &giphyProvider{apiKey: "secret"}
+		channelConfigs = map[string]*channelConfig{
+			"#random": {Channel: "#random", Rating: "pg-13"},
+			"#slack":  {Channel: "#slack", Rendition: renditionOriginal},
+		}
+		Reset(func() {
+			channelConfigs = nil
+			recent.clear()
+		})
+
+		Convey("A random gif is posted", func() {
+			So(run("#work", "cat"), ShouldStartWith, "https://media.giphy.com/media/cat")
+		})
+
+		Convey("Several different gifs can be posted", func() {
+			lines := strings.Split(run("#work", "-n", "3", "cat"), "\n")
+			So(lines, ShouldHaveLength, 3)
+			So(lines[0], ShouldNotEqual, lines[1])
+			So(lines[1], ShouldNotEqual, lines[2])
+			So(lines[0], ShouldNotEqual, lines[2])
+		})
+
+		Convey("Rating of the channel is used", func() {
+			So(run("#random", "dog"), ShouldEqual, "https://media.giphy.com/media/dog1/200.gif")
+		})
+
+		Convey("Rendition of the channel is used", func() {
+			So(run("#slack", "cat"), ShouldEndWith, "/giphy.gif")
+			rendition = renditionMp4
+			defer func() { rendition = renditionFixedHeight }()
+			So(run("#work", "cat"), ShouldEndWith, "/giphy.mp4")
+		})
+
+		Convey("Recently posted gifs are not repeated", func() {
+			posted := map[string]bool{}
+			for i := 0; i < 3; i++ {
+				posted[run("#work", "cat")] = true
+			}
+			So(posted, ShouldHaveLength, 3)
+			So(run("#other", "cat"), ShouldStartWith, "https://media.giphy.com/media/cat")
+		})
+
+		Convey("Repeats are posted when there is nothing else", func() {
+			So(run("#random", "dog"), ShouldEqual, "https://media.giphy.com/media/dog1/200.gif")
+			So(run("#random", "dog"), ShouldEqual, "https://media.giphy.com/media/dog1/200.gif")
+		})
+
+		Convey("Next pages are searched for gifs which were not posted", func() {
+			So(strings.Split(run("#work", "-n", "2", "bird"), "\n"), ShouldHaveLength, 2)
+			lines := strings.Split(run("#work", "-n", "2", "bird"), "\n")
+			So(lines, ShouldContain, "https://media.giphy.com/media/bird3/200.gif")
+			So(lines, ShouldContain, "https://media.giphy.com/media/bird4/200.gif")
+		})
+
+		Convey("Trending gifs are posted", func() {
+			lines := strings.Split(run("#work", "-n", "5", "trending"), "\n")
+			So(lines, ShouldHaveLength, 2)
+			So(lines, ShouldContain, "https://media.giphy.com/media/hot1/200.gif")
+			So(lines, ShouldContain, "https://media.giphy.com/media/hot2/200.gif")
+		})
+
+		Convey("Empty results are reported", func() {
+			So(run("#work", "nothing", "at", "all"), ShouldEqual, noGifsFound)
+		})
+
+		Convey("API key is not in errors", func() {
+			_, err := gif(&bot.Cmd{Channel: "#work", Args: []string{"leakcat"}, RawArgs: "leakcat"})
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldNotContainSubstring, "secret")
+		})
+
+		Convey("Invalid arguments are reported", func() {
+			So(run("#work"), ShouldEqual, usage)
+			So(run("#work", "-n", "3"), ShouldEqual, usage)
+			So(run("#work", "-n", "10", "cat"), ShouldEqual, usage)
+			So(run("#work", "-n", "x", "cat"), ShouldEqual, usage)
+		})
+	})
+
+	Convey("Given Tenor", t, func() {
+		webtest.Use(t, "tenor").IgnoreQuery("key")
+		web.DefaultCache.Clear()
+		backend = &tenorProvider{apiKey: "secret"}
+		rating = "pg"
+		Reset(func() {
+			rating = defaultRating
+			rendition = renditionFixedHeight
+			recent.clear()
+		})
+
+		Convey("Ratings are translated to content filters", func() {
+			So(run("#work", "trending"), ShouldEqual, "https://media.tenor.com/f1/cat.gif")
+			channelConfigs = map[string]*channelConfig{"*": {Channel: "*", Rating: "g"}}
+			defer func() { channelConfigs = nil }()
+			rendition = renditionDownsampled
+			So(run("#work", "cat"), ShouldEndWith, "/tiny.gif")
+		})
+
+		Convey("API key is not in errors", func() {
+			_, err := gif(&bot.Cmd{Channel: "#work", Args: []string{"leakcat"}, RawArgs: "leakcat"})
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldNotContainSubstring, "secret")
+		})
+	})
+}
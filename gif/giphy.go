@@ -0,0 +1,127 @@
+package gif
+
+import (
+	"net/url"
+	"strconv"
+
+	"github.com/go-chat-bot/plugins/web"
+)
+
+const (
+	giphyHost        = "api.giphy.com"
+	giphySearchURL   = "https://api.giphy.com/v1/gifs/search"
+	giphyTrendingURL = "https://api.giphy.com/v1/gifs/trending"
+)
+
+type giphy struct {
+	Data []struct {
+		BitlyURL string `json:"bitly_url"`
+		Images   struct {
+			FixedHeight struct {
+				Height string `json:"height"`
+				URL    string `json:"url"`
+				Width  string `json:"width"`
+			} `json:"fixed_height"`
+			FixedHeightDownsampled struct {
+				Height string `json:"height"`
+				URL    string `json:"url"`
+				Width  string `json:"width"`
+			} `json:"fixed_height_downsampled"`
+			FixedHeightStill struct {
+				Height string `json:"height"`
+				URL    string `json:"url"`
+				Width  string `json:"width"`
+			} `json:"fixed_height_still"`
+			FixedWidth struct {
+				Height string `json:"height"`
+				URL    string `json:"url"`
+				Width  string `json:"width"`
+			} `json:"fixed_width"`
+			FixedWidthDownsampled struct {
+				Height string `json:"height"`
+				URL    string `json:"url"`
+				Width  string `json:"width"`
+			} `json:"fixed_width_downsampled"`
+			FixedWidthStill struct {
+				Height string `json:"height"`
+				URL    string `json:"url"`
+				Width  string `json:"width"`
+			} `json:"fixed_width_still"`
+			Original struct {
+				Frames string `json:"frames"`
+				Height string `json:"height"`
+				Size   string `json:"size"`
+				URL    string `json:"url"`
+				Width  string `json:"width"`
+				Mp4    string `json:"mp4"`
+			} `json:"original"`
+		} `json:"images"`
+		Type        string `json:"type"`
+		Username    string `json:"username"`
+		BitlyGifURL string `json:"bitly_gif_url"`
+		EmbedURL    string `json:"embed_url"`
+		ID          string `json:"id"`
+		Rating      string `json:"rating"`
+		Source      string `json:"source"`
+		URL         string `json:"url"`
+	} `json:"data"`
+	Meta struct {
+		Msg    string `json:"msg"`
+		Status int64  `json:"status"`
+	} `json:"meta"`
+	Pagination struct {
+		Count      int64 `json:"count"`
+		Offset     int64 `json:"offset"`
+		TotalCount int64 `json:"total_count"`
+	} `json:"pagination"`
+}
+
+// giphyProvider searches gifs on giphy.com
+type giphyProvider struct {
+	apiKey string
+}
+
+func (p *giphyProvider) name() string {
+	return "Giphy"
+}
+
+func (p *giphyProvider) search(query, rating string, limit int, page string) ([]gifResult, string, error) {
+	params := url.Values{}
+	params.Set("limit", strconv.Itoa(limit))
+	params.Set("rating", rating)
+	if page != "" {
+		params.Set("offset", page)
+	}
+	endpoint := giphyTrendingURL
+	if query != "" {
+		endpoint = giphySearchURL
+		params.Set("q", query)
+	}
+
+	data := &giphy{}
+	// the API key is kept out of the cache key, it is stored on disk
+	cacheKey := endpoint + "?" + params.Encode()
+	params.Set("api_key", p.apiKey)
+	err := web.GetJSON(endpoint+"?"+params.Encode(), data, web.Cached(pageCacheTTL),
+		web.CacheKey(cacheKey))
+	if err != nil {
+		return nil, "", err
+	}
+	results := make([]gifResult, 0, len(data.Data))
+	for _, gif := range data.Data {
+		images := gif.Images
+		results = append(results, gifResult{ID: gif.ID, URLs: map[string]string{
+			renditionFixedHeight: images.FixedHeight.URL,
+			renditionOriginal:    images.Original.URL,
+			renditionDownsampled: images.FixedHeightDownsampled.URL,
+			renditionStill:       images.FixedHeightStill.URL,
+			renditionMp4:         images.Original.Mp4,
+		}})
+	}
+	next := ""
+	if end := data.Pagination.Offset + data.Pagination.Count; data.Pagination.Count > 0 &&
+		end < data.Pagination.TotalCount {
+		next = strconv.FormatInt(end, 10)
+	}
+	return results, next, nil
+}
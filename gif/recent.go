@@ -0,0 +1,42 @@
+package gif
+
+import (
+	"sync"
+)
+
+const maxRecent = 100 // gifs remembered per channel
+
+// recentGifs remembers IDs of gifs recently posted to channels
+type recentGifs struct {
+	sync.Mutex
+	ids map[string][]string // channel -> ids, the oldest first
+}
+
+var recent = &recentGifs{ids: make(map[string][]string)}
+
+func (r *recentGifs) seen(channel, id string) bool {
+	r.Lock()
+	defer r.Unlock()
+	for _, seen := range r.ids[channel] {
+		if seen == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *recentGifs) add(channel string, ids ...string) {
+	r.Lock()
+	defer r.Unlock()
+	list := append(r.ids[channel], ids...)
+	if len(list) > maxRecent {
+		list = append([]string(nil), list[len(list)-maxRecent:]...)
+	}
+	r.ids[channel] = list
+}
+
+func (r *recentGifs) clear() {
+	r.Lock()
+	defer r.Unlock()
+	r.ids = make(map[string][]string)
+}
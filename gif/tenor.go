@@ -0,0 +1,92 @@
+package gif
+
+import (
+	"net/url"
+	"strconv"
+
+	"github.com/go-chat-bot/plugins/web"
+)
+
+const (
+	tenorHost        = "tenor.googleapis.com"
+	tenorSearchURL   = "https://tenor.googleapis.com/v2/search"
+	tenorFeaturedURL = "https://tenor.googleapis.com/v2/featured"
+	tenorClientKey   = "go-chat-bot"
+)
+
+// tenorFormats maps renditions to Tenor media formats
+var tenorFormats = map[string]string{
+	renditionFixedHeight: "gif",
+	renditionOriginal:    "gif",
+	renditionDownsampled: "tinygif",
+	renditionStill:       "gifpreview",
+	renditionMp4:         "mp4",
+}
+
+// tenorFilters maps Giphy style ratings to Tenor content filters
+var tenorFilters = map[string]string{
+	"g":     "high",
+	"pg":    "medium",
+	"pg-13": "low",
+	"r":     "off",
+}
+
+type tenorMedia struct {
+	URL  string `json:"url"`
+	Dims []int  `json:"dims"`
+	Size int64  `json:"size"`
+}
+
+type tenor struct {
+	Results []struct {
+		ID                 string                `json:"id"`
+		ContentDescription string                `json:"content_description"`
+		ItemURL            string                `json:"itemurl"`
+		MediaFormats       map[string]tenorMedia `json:"media_formats"`
+	} `json:"results"`
+	Next string `json:"next"`
+}
+
+// tenorProvider searches gifs on tenor.com
+type tenorProvider struct {
+	apiKey string
+}
+
+func (p *tenorProvider) name() string {
+	return "Tenor"
+}
+
+func (p *tenorProvider) search(query, rating string, limit int, page string) ([]gifResult, string, error) {
+	params := url.Values{}
+	params.Set("client_key", tenorClientKey)
+	params.Set("limit", strconv.Itoa(limit))
+	params.Set("contentfilter", tenorFilters[rating])
+	params.Set("media_filter", "gif,tinygif,gifpreview,mp4")
+	if page != "" {
+		params.Set("pos", page)
+	}
+	endpoint := tenorFeaturedURL
+	if query != "" {
+		endpoint = tenorSearchURL
+		params.Set("q", query)
+	}
+
+	data := &tenor{}
+	// the API key is kept out of the cache key, it is stored on disk
+	cacheKey := endpoint + "?" + params.Encode()
+	params.Set("key", p.apiKey)
+	err := web.GetJSON(endpoint+"?"+params.Encode(), data, web.Cached(pageCacheTTL),
+		web.CacheKey(cacheKey))
+	if err != nil {
+		return nil, "", err
+	}
+	results := make([]gifResult, 0, len(data.Results))
+	for _, gif := range data.Results {
+		urls := make(map[string]string)
+		for rendition, format := range tenorFormats {
+			urls[rendition] = gif.MediaFormats[format].URL
+		}
+		results = append(results, gifResult{ID: gif.ID, URLs: urls})
+	}
+	return results, data.Next, nil
+}
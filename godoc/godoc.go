@@ -1,56 +1,165 @@
 package godoc
 
 import (
+	"errors"
 	"fmt"
+	"go/doc"
+	"log"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
 	"github.com/go-chat-bot/bot"
-	"github.com/go-chat-bot/plugins/web"
-	"net/url"
+	"github.com/go-chat-bot/plugins/origin"
 )
 
 const (
-	godocSiteURL    = "http://godoc.org"
+	offlineEnv      = "GODOC_OFFLINE"
 	noPackagesFound = "No packages found."
+	symbolNotFound  = "Symbol %s not found in %s."
+	packageNotFound = "Package %s not found."
 )
 
 var (
-	godocSearchURL = "http://api.godoc.org/search"
+	// offline answers lookups of standard library packages from GOROOT
+	offline = false
+	// symbolPattern matches exported symbols such as Client or Client.Do
+	symbolPattern = regexp.MustCompile(`^[A-Z]\w*(\.[A-Z]\w*)?$`)
+	// pathElementPattern matches an element of an import path
+	pathElementPattern = regexp.MustCompile(`^[A-Za-z0-9_.~+-]+$`)
 )
 
-type godocResults struct {
-	Results []struct {
-		Path     string `json:"path"`
-		Synopsis string `json:"synopsis"`
-	} `json:"results"`
+// validImportPath reports whether pkg is a clean import path which can be
+// used in URLs and file paths as is
+func validImportPath(pkg string) bool {
+	if pkg == "" || path.Clean(pkg) != pkg || strings.HasPrefix(pkg, "/") {
+		return false
+	}
+	for _, element := range strings.Split(pkg, "/") {
+		if element == "." || element == ".." || !pathElementPattern.MatchString(element) {
+			return false
+		}
+	}
+	return true
 }
 
-func search(cmd *bot.Cmd) (string, error) {
-	if cmd.RawArgs == "" {
-		return "", nil
+// splitSymbol splits query such as net/http.Client.Do into package path and
+// symbol. Symbol is empty if the query is not a symbol lookup. Dots in the
+// last path element which are not followed by an exported name, like in
+// gopkg.in/yaml.v3, belong to the package path.
+func splitSymbol(query string) (pkg, symbol string) {
+	if strings.ContainsAny(query, " \t") {
+		return query, ""
+	}
+	start := strings.LastIndex(query, "/") + 1
+	for i := start; i < len(query); i++ {
+		if query[i] == '.' && i > 0 && symbolPattern.MatchString(query[i+1:]) {
+			return query[:i], query[i+1:]
+		}
 	}
+	return query, ""
+}
 
-	data := &godocResults{}
+func collapse(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
 
-	url, _ := url.Parse(godocSearchURL)
-	q := url.Query()
-	q.Set("q", cmd.RawArgs)
-	url.RawQuery = q.Encode()
+// signature returns declaration of a function on a single line, other
+// declarations are shortened to their first line, e.g. "type Client struct"
+func signature(decl string) string {
+	decl = strings.TrimSpace(decl)
+	if strings.HasPrefix(decl, "func") {
+		return collapse(decl)
+	}
+	first := strings.SplitN(decl, "\n", 2)[0]
+	return strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(first), "{"))
+}
+
+// firstSentence returns the first sentence of documentation text
+func firstSentence(text string) string {
+	return new(doc.Package).Synopsis(text)
+}
+
+func docLink(pkg, symbol string) string {
+	link := pkgsiteURL + "/" + pkg
+	if symbol != "" {
+		link += "#" + symbol
+	}
+	return link
+}
+
+func formatSymbol(pkg, symbol, decl, summary string) string {
+	if summary == "" {
+		return fmt.Sprintf("%s %s", decl, docLink(pkg, symbol))
+	}
+	return fmt.Sprintf("%s - %s %s", decl, summary, docLink(pkg, symbol))
+}
 
-	err := web.GetJSON(url.String(), data)
+// lookup replies with documentation of symbol of package pkg
+func lookup(pkg, symbol string) (string, error) {
+	if !validImportPath(pkg) {
+		return fmt.Sprintf(packageNotFound, pkg), nil
+	}
+	find := lookupOnline
+	if offline && isStandard(pkg) {
+		find = lookupStandard
+	}
+	decl, summary, found, err := find(pkg, symbol)
+	if errors.Is(err, errPackageNotFound) {
+		return fmt.Sprintf(packageNotFound, pkg), nil
+	}
 	if err != nil {
 		return "", err
 	}
+	if !found {
+		return fmt.Sprintf(symbolNotFound, symbol, pkg), nil
+	}
+	return formatSymbol(pkg, symbol, decl, summary), nil
+}
+
+// search replies with the first package found for the query
+func search(query string) (string, error) {
+	if offline && isStandard(query) {
+		synopsis, err := standardSynopsis(query)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s %s", synopsis, docLink(query, "")), nil
+	}
 
-	if len(data.Results) == 0 {
+	path, synopsis, err := searchPackages(query)
+	if err != nil {
+		return "", err
+	}
+	if path == "" {
 		return noPackagesFound, nil
 	}
+	return fmt.Sprintf("%s %s", synopsis, docLink(path, "")), nil
+}
 
-	return fmt.Sprintf("%s %s/%s", data.Results[0].Synopsis, godocSiteURL, data.Results[0].Path), nil
+func godoc(cmd *bot.Cmd) (string, error) {
+	query := strings.TrimSpace(cmd.RawArgs)
+	if query == "" {
+		return "", nil
+	}
+	if pkg, symbol := splitSymbol(query); symbol != "" {
+		return lookup(pkg, symbol)
+	}
+	return search(query)
 }
 
 func init() {
+	if os.Getenv(offlineEnv) != "" {
+		offline = true
+		if goroot() == "" {
+			log.Printf("GOROOT is unknown, %s has no effect", offlineEnv)
+		}
+	}
+
 	bot.RegisterCommand(
 		"godoc",
-		"Searchs godoc.org and displays the first result.",
-		"package name",
-		search)
+		"Searches packages in pkg.go.dev or shows documentation of a symbol.",
+		"net/http or net/http.Client.Do",
+		origin.Command("godoc", godoc))
 }
@@ -1,50 +1,52 @@
 package godoc
 
 import (
-	"fmt"
-	"github.com/go-chat-bot/bot"
-	. "github.com/smartystreets/goconvey/convey"
 	"net/http"
-	"net/http/httptest"
 	"testing"
-)
 
-const (
-	validResults = `{
-    	"results": [
-	        {
-	            "path": "github.com/go-chat-bot/bot",
-	            "synopsis": "IRC bot written in go"
-	        }
-    	]
-	}`
-
-	emptyResults = `{"results":[]}`
+	"github.com/go-chat-bot/bot"
+	"github.com/go-chat-bot/plugins/web"
+	"github.com/go-chat-bot/plugins/web/webtest"
+	. "github.com/smartystreets/goconvey/convey"
 )
 
+func TestSplitSymbol(t *testing.T) {
+	Convey("Given a query", t, func() {
+		for query, expected := range map[string][2]string{
+			"net/http":                   {"net/http", ""},
+			"net/http.Client":            {"net/http", "Client"},
+			"net/http.Client.Do":         {"net/http", "Client.Do"},
+			"fmt.Println":                {"fmt", "Println"},
+			"golang.org/x/net/html":      {"golang.org/x/net/html", ""},
+			"gopkg.in/yaml.v3":           {"gopkg.in/yaml.v3", ""},
+			"gopkg.in/yaml.v3.Marshal":   {"gopkg.in/yaml.v3", "Marshal"},
+			"github.com/go-chat-bot/bot": {"github.com/go-chat-bot/bot", ""},
+			"irc bot.Cmd":                {"irc bot.Cmd", ""},
+		} {
+			pkg, symbol := splitSymbol(query)
+			So([2]string{pkg, symbol}, ShouldResemble, expected)
+		}
+	})
+}
+
 func TestGoDoc(t *testing.T) {
 	cmd := &bot.Cmd{}
-	apiResult := ""
-
-	ts := httptest.NewServer(
-		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			fmt.Fprintln(w, apiResult)
-		}))
-
-	godocSearchURL = ts.URL
 
-	Convey("Given a search query text", t, func() {
+	Convey("Given pkg.go.dev", t, func() {
+		cassette := webtest.New(t, "pkgsite")
+		client.HTTPClient = cassette.Client()
+		web.DefaultCache.Clear()
 
 		Reset(func() {
+			client.HTTPClient = nil
+			pkgsiteURL = "https://pkg.go.dev"
 			cmd.RawArgs = ""
-			apiResult = ""
 		})
 
 		Convey("When the result is empty", func() {
-			cmd.RawArgs = "non existant package"
-			apiResult = emptyResults
+			cmd.RawArgs = "nonexistent package"
 
-			s, err := search(cmd)
+			s, err := godoc(cmd)
 
 			So(err, ShouldBeNil)
 			So(s, ShouldEqual, noPackagesFound)
@@ -52,30 +54,186 @@ func TestGoDoc(t *testing.T) {
 
 		Convey("When the result is ok", func() {
 			cmd.RawArgs = "go-bot"
-			apiResult = validResults
 
-			s, err := search(cmd)
+			s, err := godoc(cmd)
 
 			So(err, ShouldBeNil)
-			So(s, ShouldEqual, "IRC bot written in go http://godoc.org/github.com/go-chat-bot/bot")
+			So(s, ShouldEqual, "IRC bot written in go https://pkg.go.dev/github.com/go-chat-bot/bot")
 		})
 
 		Convey("When the query is empty", func() {
 			cmd.RawArgs = ""
 
-			s, err := search(cmd)
+			s, err := godoc(cmd)
 
 			So(err, ShouldBeNil)
 			So(s, ShouldEqual, "")
 		})
 
-		Convey("When the api is unreachable", func() {
-			godocSearchURL = "127.0.0.1:0"
+		Convey("When a method is looked up", func() {
+			cmd.RawArgs = "net/http.Client.Do"
+
+			s, err := godoc(cmd)
+
+			So(err, ShouldBeNil)
+			So(s, ShouldEqual, "func (c *Client) Do(req *Request) (*Response, error) - "+
+				"Do sends an HTTP request and returns an HTTP response, following policy "+
+				"(such as redirects, cookies, auth) as configured on the client. "+
+				"https://pkg.go.dev/net/http#Client.Do")
+		})
+
+		Convey("When a type is looked up", func() {
+			cmd.RawArgs = "net/http.Client"
+
+			s, err := godoc(cmd)
+
+			So(err, ShouldBeNil)
+			So(s, ShouldEqual, "type Client struct - A Client is an HTTP client. "+
+				"https://pkg.go.dev/net/http#Client")
+		})
+
+		Convey("When a constant is looked up", func() {
+			cmd.RawArgs = "net/http.MethodGet"
+
+			s, err := godoc(cmd)
+
+			So(err, ShouldBeNil)
+			So(s, ShouldEqual, `const MethodGet = "GET" - Common HTTP methods. `+
+				"https://pkg.go.dev/net/http#MethodGet")
+		})
+
+		Convey("When the symbol doesn't exist", func() {
+			cmd.RawArgs = "net/http.Nothing"
+
+			s, err := godoc(cmd)
+
+			So(err, ShouldBeNil)
+			So(s, ShouldEqual, "Symbol Nothing not found in net/http.")
+		})
+
+		Convey("When the package doesn't exist", func() {
+			cmd.RawArgs = "example.com/missing.Thing"
+
+			s, err := godoc(cmd)
+
+			So(err, ShouldBeNil)
+			So(s, ShouldEqual, "Package example.com/missing not found.")
+		})
+
+		Convey("When the package is not an import path", func() {
+			for _, pkg := range []string{"net/http?tab=doc", "net/http#x", "net/%2e%2e/x", "net/../x", "/net/http"} {
+				cmd.RawArgs = pkg + ".Client"
+
+				s, err := godoc(cmd)
+
+				So(err, ShouldBeNil)
+				So(s, ShouldEqual, "Package "+pkg+" not found.")
+			}
+		})
+
+		Convey("When the site is unreachable", func() {
+			client.HTTPClient = http.DefaultClient
+			pkgsiteURL = "127.0.0.1:0"
 			cmd.RawArgs = "go-bot"
 
-			_, err := search(cmd)
+			_, err := godoc(cmd)
 
 			So(err, ShouldNotBeNil)
 		})
 	})
 }
+
+func TestOffline(t *testing.T) {
+	cmd := &bot.Cmd{}
+
+	Convey("Given offline mode", t, func() {
+		if goroot() == "" {
+			SkipSo("GOROOT is unknown")
+			return
+		}
+		offline = true
+		// any request to pkg.go.dev fails the test
+		client.HTTPClient = webtest.New(t, "empty").Client()
+
+		Reset(func() {
+			offline = false
+			client.HTTPClient = nil
+		})
+
+		Convey("A method of a standard package is looked up in GOROOT", func() {
+			cmd.RawArgs = "net/http.Client.Do"
+
+			s, err := godoc(cmd)
+
+			So(err, ShouldBeNil)
+			So(s, ShouldStartWith, "func (c *Client) Do(req *Request) (*Response, error) - Do sends an HTTP request")
+			So(s, ShouldEndWith, " https://pkg.go.dev/net/http#Client.Do")
+		})
+
+		Convey("A function, a type and a constant are looked up", func() {
+			cmd.RawArgs = "strings.ToUpper"
+			s, err := godoc(cmd)
+			So(err, ShouldBeNil)
+			So(s, ShouldStartWith, "func ToUpper(s string) string - ToUpper returns s with all Unicode letters mapped to their upper case.")
+
+			cmd.RawArgs = "net/http.NewRequest"
+			s, err = godoc(cmd)
+			So(err, ShouldBeNil)
+			So(s, ShouldStartWith, "func NewRequest(method, url string, body io.Reader) (*Request, error)")
+
+			cmd.RawArgs = "strings.Builder"
+			s, err = godoc(cmd)
+			So(err, ShouldBeNil)
+			So(s, ShouldStartWith, "type Builder struct - A Builder is used to efficiently build a string")
+
+			cmd.RawArgs = "net/http.MethodGet"
+			s, err = godoc(cmd)
+			So(err, ShouldBeNil)
+			So(s, ShouldStartWith, `const MethodGet = "GET" - `)
+		})
+
+		Convey("A standard package is described", func() {
+			cmd.RawArgs = "net/http"
+
+			s, err := godoc(cmd)
+
+			So(err, ShouldBeNil)
+			So(s, ShouldStartWith, "Package http provides HTTP client and server implementations.")
+			So(s, ShouldEndWith, " https://pkg.go.dev/net/http")
+		})
+
+		Convey("Packages outside of GOROOT are not read", func() {
+			for _, pkg := range []string{"net/../../../../etc", "/etc", "net/./http", "./net", "net/"} {
+				So(isStandard(pkg), ShouldBeFalse)
+				_, _, err := loadStandard(pkg)
+				So(err, ShouldEqual, errPackageNotFound)
+			}
+
+			cmd.RawArgs = "net/../../../../home/x/proj.Secret"
+
+			s, err := godoc(cmd)
+
+			So(err, ShouldBeNil)
+			So(s, ShouldEqual, "Package net/../../../../home/x/proj not found.")
+		})
+
+		Convey("A missing symbol is reported", func() {
+			cmd.RawArgs = "strings.Nothing"
+
+			s, err := godoc(cmd)
+
+			So(err, ShouldBeNil)
+			So(s, ShouldEqual, "Symbol Nothing not found in strings.")
+		})
+	})
+}
+
+func TestDeclarationLine(t *testing.T) {
+	Convey("Given declaration of a group", t, func() {
+		So(declarationLine("const (\n\tA = 1\n\tB = 2\n)", "B"), ShouldEqual, "const B = 2")
+		So(declarationLine("var X = 1", "X"), ShouldEqual, "var X = 1")
+		So(declarationLine("const (\n)", "B"), ShouldEqual, "const (")
+		So(declarationLine("", "B"), ShouldEqual, "")
+		So(declarationLine(" \n\t", "B"), ShouldEqual, "")
+	})
+}
@@ -0,0 +1,210 @@
+package godoc
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/doc"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+func goroot() string {
+	if dir := os.Getenv("GOROOT"); dir != "" {
+		return dir
+	}
+	return runtime.GOROOT()
+}
+
+// standardDir returns directory of standard library package pkg in GOROOT.
+// ok is false for paths which are not clean import paths or which would
+// lead outside of GOROOT/src.
+func standardDir(pkg string) (dir string, ok bool) {
+	if goroot() == "" || pkg == "" || path.Clean(pkg) != pkg ||
+		strings.HasPrefix(pkg, "/") || strings.Contains(pkg, `\`) {
+		return "", false
+	}
+	for _, element := range strings.Split(pkg, "/") {
+		if element == ".." || element == "." {
+			return "", false
+		}
+	}
+	if strings.Contains(strings.SplitN(pkg, "/", 2)[0], ".") {
+		return "", false
+	}
+	src := filepath.Join(goroot(), "src")
+	dir = filepath.Join(src, filepath.FromSlash(pkg))
+	if rel, err := filepath.Rel(src, dir); err != nil || rel == ".." ||
+		strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return dir, true
+}
+
+// isStandard reports whether pkg is a standard library package available in
+// GOROOT
+func isStandard(pkg string) bool {
+	dir, ok := standardDir(pkg)
+	if !ok {
+		return false
+	}
+	info, err := os.Stat(dir)
+	return err == nil && info.IsDir()
+}
+
+// loadStandard reads documentation of standard library package pkg from its
+// sources in GOROOT
+func loadStandard(pkg string) (*doc.Package, *token.FileSet, error) {
+	dir, ok := standardDir(pkg)
+	if !ok {
+		return nil, nil, errPackageNotFound
+	}
+	buildPkg, err := build.Default.ImportDir(dir, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	fset := token.NewFileSet()
+	files := make([]*ast.File, 0, len(buildPkg.GoFiles))
+	for _, name := range buildPkg.GoFiles {
+		file, err := parser.ParseFile(fset, filepath.Join(dir, name), nil, parser.ParseComments)
+		if err != nil {
+			return nil, nil, err
+		}
+		files = append(files, file)
+	}
+	docPkg, err := doc.NewFromFiles(fset, files, pkg)
+	return docPkg, fset, err
+}
+
+func standardSynopsis(pkg string) (string, error) {
+	docPkg, _, err := loadStandard(pkg)
+	if err != nil {
+		return "", err
+	}
+	return docPkg.Synopsis(docPkg.Doc), nil
+}
+
+func printNode(fset *token.FileSet, node interface{}) string {
+	var buf bytes.Buffer
+	if err := printer.Fprint(&buf, fset, node); err != nil {
+		return ""
+	}
+	return buf.String()
+}
+
+func funcSignature(fset *token.FileSet, f *doc.Func) string {
+	decl := *f.Decl
+	decl.Body = nil
+	decl.Doc = nil
+	return collapse(printNode(fset, &decl))
+}
+
+func typeSignature(fset *token.FileSet, t *doc.Type) string {
+	for _, spec := range t.Decl.Specs {
+		typeSpec, ok := spec.(*ast.TypeSpec)
+		if !ok || typeSpec.Name.Name != t.Name {
+			continue
+		}
+		switch typeSpec.Type.(type) {
+		case *ast.StructType:
+			return fmt.Sprintf("type %s struct", t.Name)
+		case *ast.InterfaceType:
+			return fmt.Sprintf("type %s interface", t.Name)
+		}
+		spec := *typeSpec
+		spec.Doc, spec.Comment = nil, nil
+		return "type " + collapse(printNode(fset, &spec))
+	}
+	return "type " + t.Name
+}
+
+// valueSignature returns declaration of name from a group of constants or
+// variables, ok is false if the group doesn't declare it
+func valueSignature(fset *token.FileSet, v *doc.Value, name string) (decl, summary string, ok bool) {
+	for _, spec := range v.Decl.Specs {
+		valueSpec, isValue := spec.(*ast.ValueSpec)
+		if !isValue {
+			continue
+		}
+		for _, ident := range valueSpec.Names {
+			if ident.Name != name {
+				continue
+			}
+			copied := *valueSpec
+			copied.Doc, copied.Comment = nil, nil
+			summary = v.Doc
+			if valueSpec.Doc != nil {
+				summary = valueSpec.Doc.Text()
+			}
+			return v.Decl.Tok.String() + " " + collapse(printNode(fset, &copied)),
+				firstSentence(summary), true
+		}
+	}
+	return "", "", false
+}
+
+func findValue(fset *token.FileSet, values []*doc.Value, name string) (decl, summary string, ok bool) {
+	for _, v := range values {
+		if decl, summary, ok = valueSignature(fset, v, name); ok {
+			return decl, summary, true
+		}
+	}
+	return "", "", false
+}
+
+// lookupStandard finds symbol of standard library package pkg in GOROOT,
+// found is false if there is no such symbol
+func lookupStandard(pkg, symbol string) (decl, summary string, found bool, err error) {
+	docPkg, fset, err := loadStandard(pkg)
+	if err != nil {
+		return "", "", false, err
+	}
+	typeName, member, isMember := strings.Cut(symbol, ".")
+
+	if !isMember {
+		for _, f := range docPkg.Funcs {
+			if f.Name == symbol {
+				return funcSignature(fset, f), firstSentence(f.Doc), true, nil
+			}
+		}
+		values := append(docPkg.Consts, docPkg.Vars...)
+		for _, t := range docPkg.Types {
+			values = append(values, t.Consts...)
+			values = append(values, t.Vars...)
+		}
+		if decl, summary, ok := findValue(fset, values, symbol); ok {
+			return decl, summary, true, nil
+		}
+	}
+
+	for _, t := range docPkg.Types {
+		if !isMember {
+			if t.Name == symbol {
+				return typeSignature(fset, t), firstSentence(t.Doc), true, nil
+			}
+			// constructors are documented with their type
+			for _, f := range t.Funcs {
+				if f.Name == symbol {
+					return funcSignature(fset, f), firstSentence(f.Doc), true, nil
+				}
+			}
+			continue
+		}
+		if t.Name != typeName {
+			continue
+		}
+		for _, m := range t.Methods {
+			if m.Name == member {
+				return funcSignature(fset, m), firstSentence(m.Doc), true, nil
+			}
+		}
+	}
+	return "", "", false, nil
+}
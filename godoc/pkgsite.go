@@ -0,0 +1,188 @@
+package godoc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-chat-bot/plugins/web"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+const (
+	pageCacheTTL   = time.Hour
+	maxPageSize    = 16 * 1024 * 1024 // documentation of big packages is large
+	searchSnippet  = "SearchSnippet"
+	snippetSummary = "SearchSnippet-synopsis"
+	declaration    = "Documentation-declaration"
+)
+
+var (
+	pkgsiteURL = "https://pkg.go.dev"
+	client     = &web.Client{MaxBodySize: maxPageSize}
+
+	errPackageNotFound = errors.New("package not found")
+)
+
+func attr(n *html.Node, name string) string {
+	for _, a := range n.Attr {
+		if a.Key == name {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func hasClass(n *html.Node, class string) bool {
+	return n.Type == html.ElementNode && contains(strings.Fields(attr(n, "class")), class)
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// find returns the first node under n, including n, for which match is true
+func find(n *html.Node, match func(*html.Node) bool) *html.Node {
+	if match(n) {
+		return n
+	}
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if found := find(child, match); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// nextSibling returns the first element after n for which match is true
+func nextSibling(n *html.Node, match func(*html.Node) bool) *html.Node {
+	for n = n.NextSibling; n != nil; n = n.NextSibling {
+		if n.Type == html.ElementNode && match(n) {
+			return n
+		}
+	}
+	return nil
+}
+
+func text(n *html.Node) string {
+	if n == nil {
+		return ""
+	}
+	var b strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			b.WriteString(n.Data)
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(n)
+	return b.String()
+}
+
+func getPage(pageURL string) (*html.Node, error) {
+	body, err := client.GetBody(context.Background(), pageURL, web.Cached(pageCacheTTL))
+	if err != nil {
+		return nil, err
+	}
+	return html.Parse(bytes.NewReader(body))
+}
+
+// searchPackages returns path and synopsis of the first package found by
+// pkg.go.dev for query, empty path if there is none
+func searchPackages(query string) (path, synopsis string, err error) {
+	q := url.Values{}
+	q.Set("q", query)
+	q.Set("m", "package")
+	page, err := getPage(pkgsiteURL + "/search?" + q.Encode())
+	if err != nil {
+		return "", "", err
+	}
+	snippet := find(page, func(n *html.Node) bool { return hasClass(n, searchSnippet) })
+	if snippet == nil {
+		return "", "", nil
+	}
+	link := find(snippet, func(n *html.Node) bool {
+		return n.DataAtom == atom.A && strings.HasPrefix(attr(n, "href"), "/")
+	})
+	if link == nil {
+		return "", "", nil
+	}
+	summary := find(snippet, func(n *html.Node) bool { return hasClass(n, snippetSummary) })
+	return strings.TrimPrefix(attr(link, "href"), "/"), collapse(text(summary)), nil
+}
+
+// declarationLine returns declaration of symbol from a group of constants or
+// variables such as const MethodGet = "GET"
+func declarationLine(group, symbol string) string {
+	words := strings.Fields(group)
+	if len(words) == 0 {
+		return ""
+	}
+	keyword := words[0]
+	for _, line := range strings.Split(group, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 0 && fields[0] == keyword {
+			fields = fields[1:]
+		}
+		if len(fields) > 0 && fields[0] == symbol {
+			return keyword + " " + strings.Join(fields, " ")
+		}
+	}
+	return strings.TrimSpace(strings.SplitN(group, "\n", 2)[0])
+}
+
+// lookupOnline finds symbol of package pkg at pkg.go.dev, found is false if
+// there is no such symbol
+func lookupOnline(pkg, symbol string) (decl, summary string, found bool, err error) {
+	elements := strings.Split(pkg, "/")
+	for i, element := range elements {
+		elements[i] = url.PathEscape(element)
+	}
+	page, err := getPage(pkgsiteURL + "/" + strings.Join(elements, "/"))
+	var statusErr *web.StatusError
+	if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound {
+		return "", "", false, errPackageNotFound
+	}
+	if err != nil {
+		return "", "", false, err
+	}
+	target := find(page, func(n *html.Node) bool {
+		return n.Type == html.ElementNode && attr(n, "id") == symbol
+	})
+	if target == nil {
+		return "", "", false, nil
+	}
+
+	// constants and variables are marked inside declaration of their group,
+	// other symbols have a header followed by declaration and documentation
+	var declNode *html.Node
+	for n := target; n != nil; n = n.Parent {
+		if hasClass(n, declaration) {
+			declNode = n
+		}
+	}
+	if declNode != nil {
+		decl = declarationLine(text(declNode), symbol)
+	} else {
+		declNode = nextSibling(target, func(n *html.Node) bool { return hasClass(n, declaration) })
+		if declNode == nil {
+			return "", "", false, nil
+		}
+		decl = signature(text(declNode))
+	}
+	paragraph := nextSibling(declNode, func(n *html.Node) bool { return n.DataAtom == atom.P })
+	return decl, firstSentence(text(paragraph)), true, nil
+}
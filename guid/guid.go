@@ -5,6 +5,7 @@ import (
 
 	uuid "github.com/beevik/guid"
 	"github.com/go-chat-bot/bot"
+	"github.com/go-chat-bot/plugins/origin"
 )
 
 const (
@@ -32,5 +33,5 @@ func init() {
 		"guid",
 		"Generates GUID",
 		"",
-		guid)
+		origin.Command("guid", guid))
 }
@@ -14,6 +14,7 @@ import (
 	gojira "github.com/andygrunwald/go-jira"
 	"github.com/davecgh/go-spew/spew"
 	"github.com/go-chat-bot/bot"
+	"github.com/go-chat-bot/plugins/origin"
 )
 
 const (
@@ -397,24 +398,24 @@ func init() {
 
 	bot.RegisterPassiveCommandV2(
 		"jira",
-		jira)
+		origin.PassiveV2("jira", jira))
 
 	if len(notifyNewConfig) > 0 {
 		bot.RegisterPeriodicCommandV2(
 			"periodicJIRANotifyNew",
-			bot.PeriodicConfig{
+			origin.Periodic("jira", bot.PeriodicConfig{
 				CronSpec:  fmt.Sprintf("*/%d * * * *", notifyInterval),
 				CmdFuncV2: periodicJIRANotifyNew,
-			})
+			}))
 	}
 	log.Printf("New issue notifications set up for %d JIRA projects", len(notifyNewConfig))
 	if len(notifyResConfig) > 0 {
 		bot.RegisterPeriodicCommandV2(
 			"periodicJIRANotifyResolved",
-			bot.PeriodicConfig{
+			origin.Periodic("jira", bot.PeriodicConfig{
 				CronSpec:  fmt.Sprintf("*/%d * * * *", notifyInterval),
 				CmdFuncV2: periodicJIRANotifyResolved,
-			})
+			}))
 	}
 	log.Printf("Resolved issue notifications set up for %d JIRA projects", len(notifyResConfig))
 	log.Printf("JIRA plugin initialization successful")
@@ -0,0 +1,265 @@
+// Package origin keeps track of which plugin produced messages sent by the
+// bot. Filters only get the target and text of outgoing messages, so plugins
+// register their commands through the wrappers below and filters use Lookup
+// to find out where a message came from. Each recorded message is attributed
+// to one outgoing message, also when plugins send the same text.
+package origin
+
+import (
+	"sync"
+	"time"
+
+	"github.com/go-chat-bot/bot"
+)
+
+// Kinds of messages
+const (
+	KindCommand  = "command"
+	KindPassive  = "passive"
+	KindPeriodic = "periodic"
+)
+
+const (
+	// ttl is how long messages are remembered, filters run right after the
+	// message is produced
+	ttl = time.Minute
+	// sweepSize is the number of entries above which expired entries are
+	// removed when recording new ones
+	sweepSize = 1024
+)
+
+type entry struct {
+	plugin  string
+	kind    string
+	expires time.Time
+}
+
+var (
+	mu sync.Mutex
+	// pending origins of messages which no filter looked up yet by target
+	// and message, oldest first. Plugins may send the same text to the same
+	// target, each message takes its own origin.
+	pending = make(map[string][]entry)
+	// claimed origins of messages going through filters. The bot passes the
+	// same FilterCmd to all filters of a message, so filters running after
+	// one which changed the message still find its origin.
+	claimed = make(map[*bot.FilterCmd]entry)
+)
+
+func key(target, message string) string {
+	return target + "\x00" + message
+}
+
+// Record remembers that plugin produced message sent to target
+func Record(plugin, target, message string) {
+	record(entry{plugin: plugin}, target, message)
+}
+
+// sweep removes expired entries. Must be locked.
+func sweep(now time.Time) {
+	for k, queue := range pending {
+		fresh := queue[:0]
+		for _, e := range queue {
+			if !now.After(e.expires) {
+				fresh = append(fresh, e)
+			}
+		}
+		if len(fresh) == 0 {
+			delete(pending, k)
+		} else {
+			pending[k] = fresh
+		}
+	}
+	for cmd, e := range claimed {
+		if now.After(e.expires) {
+			delete(claimed, cmd)
+		}
+	}
+}
+
+func record(e entry, target, message string) {
+	if e.plugin == "" || message == "" {
+		return
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	now := time.Now()
+	if len(pending)+len(claimed) >= sweepSize {
+		sweep(now)
+	}
+	e.expires = now.Add(ttl)
+	k := key(target, message)
+	pending[k] = append(pending[k], e)
+}
+
+// lookup returns origin claimed by cmd. The first lookup of a message claims
+// the oldest pending origin of its target and text.
+func lookup(cmd *bot.FilterCmd) entry {
+	mu.Lock()
+	defer mu.Unlock()
+	now := time.Now()
+	if e, found := claimed[cmd]; found {
+		return e
+	}
+	k := key(cmd.Target, cmd.Message)
+	queue := pending[k]
+	for len(queue) > 0 && now.After(queue[0].expires) {
+		queue = queue[1:]
+	}
+	if len(queue) == 0 {
+		delete(pending, k)
+		return entry{}
+	}
+	e := queue[0]
+	if len(queue) == 1 {
+		delete(pending, k)
+	} else {
+		pending[k] = queue[1:]
+	}
+	if len(pending)+len(claimed) >= sweepSize {
+		sweep(now)
+	}
+	e.expires = now.Add(ttl)
+	claimed[cmd] = e
+	return e
+}
+
+// Lookup returns name of the plugin which produced message going through
+// filters or empty string if it is not known. Every filter of the message
+// gets the same answer, also when a filter before it changed the message.
+func Lookup(cmd *bot.FilterCmd) string {
+	return lookup(cmd).plugin
+}
+
+// LookupKind returns kind of command which produced message going through
+// filters (KindCommand, KindPassive or KindPeriodic) or empty string if it
+// is not known
+func LookupKind(cmd *bot.FilterCmd) string {
+	return lookup(cmd).kind
+}
+
+// Clear forgets all recorded messages
+func Clear() {
+	mu.Lock()
+	defer mu.Unlock()
+	pending = make(map[string][]entry)
+	claimed = make(map[*bot.FilterCmd]entry)
+}
+
+func recordResults(e entry, channel string, results ...bot.CmdResult) {
+	for _, result := range results {
+		target := result.Channel
+		if target == "" {
+			target = channel
+		}
+		record(e, target, result.Message)
+	}
+}
+
+// forward returns copy of result whose messages are recorded on the way to
+// the bot. Done is forwarded only after all messages so none gets lost.
+func forward(e entry, channel string, result bot.CmdResultV3) bot.CmdResultV3 {
+	if result.Message == nil || result.Done == nil {
+		return result
+	}
+	target := result.Channel
+	if target == "" {
+		target = channel
+	}
+	wrapped := result
+	wrapped.Message = make(chan string)
+	wrapped.Done = make(chan bool, 1)
+	send := func(message string) {
+		record(e, target, message)
+		wrapped.Message <- message
+	}
+	go func() {
+		for {
+			select {
+			case message := <-result.Message:
+				send(message)
+			case done := <-result.Done:
+				// buffered messages may still be waiting
+			drain:
+				for {
+					select {
+					case message := <-result.Message:
+						send(message)
+					default:
+						break drain
+					}
+				}
+				wrapped.Done <- done
+				return
+			}
+		}
+	}()
+	return wrapped
+}
+
+// Command wraps command function so that its replies are attributed to plugin
+func Command(plugin string, cmdFunc func(*bot.Cmd) (string, error)) func(*bot.Cmd) (string, error) {
+	return func(cmd *bot.Cmd) (string, error) {
+		message, err := cmdFunc(cmd)
+		record(entry{plugin: plugin, kind: KindCommand}, cmd.Channel, message)
+		return message, err
+	}
+}
+
+// CommandV2 wraps command function so that its replies are attributed to
+// plugin
+func CommandV2(plugin string, cmdFunc func(*bot.Cmd) (bot.CmdResult, error)) func(*bot.Cmd) (bot.CmdResult, error) {
+	return func(cmd *bot.Cmd) (bot.CmdResult, error) {
+		result, err := cmdFunc(cmd)
+		recordResults(entry{plugin: plugin, kind: KindCommand}, cmd.Channel, result)
+		return result, err
+	}
+}
+
+// CommandV3 wraps command function so that its replies are attributed to
+// plugin
+func CommandV3(plugin string, cmdFunc func(*bot.Cmd) (bot.CmdResultV3, error)) func(*bot.Cmd) (bot.CmdResultV3, error) {
+	return func(cmd *bot.Cmd) (bot.CmdResultV3, error) {
+		result, err := cmdFunc(cmd)
+		return forward(entry{plugin: plugin, kind: KindCommand}, cmd.Channel, result), err
+	}
+}
+
+// Passive wraps passive command function so that its replies are attributed
+// to plugin
+func Passive(plugin string, cmdFunc func(*bot.PassiveCmd) (string, error)) func(*bot.PassiveCmd) (string, error) {
+	return func(cmd *bot.PassiveCmd) (string, error) {
+		message, err := cmdFunc(cmd)
+		record(entry{plugin: plugin, kind: KindPassive}, cmd.Channel, message)
+		return message, err
+	}
+}
+
+// PassiveV2 wraps passive command function so that its replies are
+// attributed to plugin
+func PassiveV2(plugin string, cmdFunc func(*bot.PassiveCmd) (bot.CmdResultV3, error)) func(*bot.PassiveCmd) (bot.CmdResultV3, error) {
+	return func(cmd *bot.PassiveCmd) (bot.CmdResultV3, error) {
+		result, err := cmdFunc(cmd)
+		return forward(entry{plugin: plugin, kind: KindPassive}, cmd.Channel, result), err
+	}
+}
+
+// Periodic returns copy of config whose messages are attributed to plugin
+func Periodic(plugin string, config bot.PeriodicConfig) bot.PeriodicConfig {
+	e := entry{plugin: plugin, kind: KindPeriodic}
+	if cmdFunc := config.CmdFunc; cmdFunc != nil {
+		config.CmdFunc = func(channel string) (string, error) {
+			message, err := cmdFunc(channel)
+			record(e, channel, message)
+			return message, err
+		}
+	}
+	if cmdFuncV2 := config.CmdFuncV2; cmdFuncV2 != nil {
+		config.CmdFuncV2 = func() ([]bot.CmdResult, error) {
+			results, err := cmdFuncV2()
+			recordResults(e, "", results...)
+			return results, err
+		}
+	}
+	return config
+}
@@ -0,0 +1,141 @@
+package origin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-chat-bot/bot"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// filtered returns outgoing message as the bot passes it to filters
+func filtered(target, message string) *bot.FilterCmd {
+	return &bot.FilterCmd{Target: target, Message: message}
+}
+
+func TestOrigin(t *testing.T) {
+	Convey("Given recorded messages", t, func() {
+		Clear()
+
+		Convey("Plugin is found by target and message", func() {
+			Record("jira", "#go", "PROJ-1 is resolved")
+
+			So(Lookup(filtered("#other", "PROJ-1 is resolved")), ShouldEqual, "")
+			So(Lookup(filtered("#go", "PROJ-2 is resolved")), ShouldEqual, "")
+			So(Lookup(filtered("#go", "PROJ-1 is resolved")), ShouldEqual, "jira")
+		})
+
+		Convey("Empty messages are not recorded", func() {
+			Record("jira", "#go", "")
+
+			So(pending, ShouldBeEmpty)
+		})
+
+		Convey("Each message takes its own origin", func() {
+			Record("jira", "#go", "done")
+			Record("cachet", "#go", "done")
+			first, second := filtered("#go", "done"), filtered("#go", "done")
+
+			So(Lookup(first), ShouldEqual, "jira")
+			So(Lookup(second), ShouldEqual, "cachet")
+			So(Lookup(first), ShouldEqual, "jira")
+			So(LookupKind(second), ShouldEqual, "")
+			So(Lookup(filtered("#go", "done")), ShouldEqual, "")
+		})
+
+		Convey("Expired entries are swept", func() {
+			Record("jira", "#go", "done")
+			cmd := filtered("#go", "other")
+			claimed[cmd] = entry{plugin: "jira"}
+
+			sweep(time.Now().Add(2 * ttl))
+
+			So(pending, ShouldBeEmpty)
+			So(claimed, ShouldBeEmpty)
+		})
+	})
+
+	Convey("Given wrapped commands", t, func() {
+		Clear()
+		cmd := &bot.Cmd{Channel: "#go"}
+
+		Convey("Replies of commands are recorded", func() {
+			reply := Command("hello", func(*bot.Cmd) (string, error) {
+				return "hello", nil
+			})
+
+			message, err := reply(cmd)
+
+			So(err, ShouldBeNil)
+			So(message, ShouldEqual, "hello")
+			filter := filtered("#go", "hello")
+			So(Lookup(filter), ShouldEqual, "hello")
+			So(LookupKind(filter), ShouldEqual, KindCommand)
+		})
+
+		Convey("Replies of V2 commands are recorded for their channel", func() {
+			reply := CommandV2("puppet", func(*bot.Cmd) (bot.CmdResult, error) {
+				return bot.CmdResult{Channel: "#other", Message: "hi"}, nil
+			})
+
+			reply(cmd)
+
+			So(Lookup(filtered("#other", "hi")), ShouldEqual, "puppet")
+		})
+
+		Convey("Replies of V3 commands are forwarded before done", func() {
+			reply := CommandV3("links", func(*bot.Cmd) (bot.CmdResultV3, error) {
+				result := bot.CmdResultV3{
+					Message: make(chan string, 3),
+					Done:    make(chan bool, 1)}
+				result.Message <- "first"
+				result.Message <- "second"
+				result.Message <- "third"
+				result.Done <- true
+				return result, nil
+			})
+
+			result, err := reply(cmd)
+
+			So(err, ShouldBeNil)
+			var messages []string
+			for done := false; !done; {
+				select {
+				case message := <-result.Message:
+					messages = append(messages, message)
+				case <-result.Done:
+					done = true
+				}
+			}
+			So(messages, ShouldResemble, []string{"first", "second", "third"})
+			So(Lookup(filtered("#go", "third")), ShouldEqual, "links")
+		})
+
+		Convey("Periodic messages are recorded", func() {
+			config := Periodic("cachet", bot.PeriodicConfig{
+				CmdFuncV2: func() ([]bot.CmdResult, error) {
+					return []bot.CmdResult{{Channel: "#ops", Message: "down"}}, nil
+				},
+			})
+
+			config.CmdFuncV2()
+
+			filter := filtered("#ops", "down")
+			So(Lookup(filter), ShouldEqual, "cachet")
+			So(LookupKind(filter), ShouldEqual, KindPeriodic)
+		})
+
+		Convey("Messages changed by filters keep their origin", func() {
+			reply := Passive("catfacts", func(*bot.PassiveCmd) (string, error) {
+				return "cats sleep a lot", nil
+			})
+			reply(&bot.PassiveCmd{Channel: "#go"})
+			filter := filtered("#go", "cats sleep a lot")
+
+			So(Lookup(filter), ShouldEqual, "catfacts")
+			filter.Message = "cats sleep a lot (suppressed 2 duplicate messages)"
+			So(Lookup(filter), ShouldEqual, "catfacts")
+			So(LookupKind(filter), ShouldEqual, KindPassive)
+		})
+	})
+}
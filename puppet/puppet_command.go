@@ -1,26 +1,125 @@
 package puppet
 
 import (
+	"fmt"
+	"log"
+	"os"
 	"strings"
+	"time"
 
 	"github.com/go-chat-bot/bot"
+	"github.com/go-chat-bot/plugins/origin"
 )
 
 const (
-	seeUsage = "Invalid args, see usage with: !help puppet."
+	seeUsage    = "Invalid args, see usage with: !help puppet."
+	notAllowed  = "You are not allowed to puppet the bot."
+	nicksEnv    = "PUPPET_NICKS"
+	channelsEnv = "PUPPET_CHANNELS"
+	ircProtocol = "irc"
 )
 
+var (
+	// nicks allowed to puppet the bot, nobody if empty
+	allowedNicks []string
+	// channels the bot can be puppeted into, any if empty
+	allowedChannels []string
+)
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+func splitList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func nick(command *bot.Cmd) string {
+	if command.User == nil {
+		return ""
+	}
+	return command.User.Nick
+}
+
+func protocol(command *bot.Cmd) string {
+	if command.ChannelData == nil {
+		return ""
+	}
+	return command.ChannelData.Protocol
+}
+
+// action formats message as what /me sends: CTCP ACTION on IRC, italics on
+// other protocols which don't know CTCP
+func action(protocol, message string) string {
+	if protocol == ircProtocol {
+		return "\x01ACTION " + message + "\x01"
+	}
+	return "_" + message + "_"
+}
+
 func sendMessage(command *bot.Cmd) (result bot.CmdResult, err error) {
 	result = bot.CmdResult{}
 
-	if !argsValid(command.Args) {
+	args := command.Args
+	scheduled := len(args) > 0 && args[0] == "at"
+	var at time.Time
+	if scheduled {
+		if len(args) < 2 {
+			result.Message = seeUsage
+			return
+		}
+		if at, err = nextOccurrence(args[1], time.Now()); err != nil {
+			result.Message = seeUsage
+			return result, nil
+		}
+		args = args[2:]
+	}
+	if !argsValid(args) {
 		result.Message = seeUsage
 		return
 	}
 
-	result.Channel = command.Args[1]
-	result.Message = strings.Join(command.Args[2:], " ")
-	return
+	verb, channel, message := args[0], args[1], strings.Join(args[2:], " ")
+	if !contains(allowedNicks, nick(command)) {
+		log.Printf("%s is not allowed to puppet: %s %s %s", nick(command), verb, channel, message)
+		result.Message = notAllowed
+		return
+	}
+	if len(allowedChannels) > 0 && !contains(allowedChannels, channel) {
+		result.Message = fmt.Sprintf("Puppeting into %s is not allowed.", channel)
+		return
+	}
+
+	puppeted := bot.CmdResult{Channel: channel, Message: message}
+	if verb == "act" {
+		puppeted.Message = action(protocol(command), message)
+	}
+	if scheduled {
+		schedule.add(scheduledMessage{
+			at:      at,
+			nick:    nick(command),
+			verb:    verb,
+			message: message,
+			result:  puppeted,
+		})
+		log.Printf("%s scheduled puppet %s in %s at %s: %s", nick(command), verb, channel,
+			at.Format(time.RFC3339), message)
+		result.Message = fmt.Sprintf("OK, I will send it to %s at %s", channel, at.Format("15:04"))
+		return
+	}
+	log.Printf("%s puppeted %s in %s: %s", nick(command), verb, channel, message)
+	return puppeted, nil
 }
 
 func argsValid(args []string) bool {
@@ -32,9 +131,21 @@ func validCommand(cmd string) bool {
 }
 
 func init() {
+	allowedNicks = splitList(os.Getenv(nicksEnv))
+	allowedChannels = splitList(os.Getenv(channelsEnv))
+	if len(allowedNicks) == 0 {
+		log.Printf("%s env variable is not set, nobody can puppet the bot", nicksEnv)
+	}
+
 	bot.RegisterCommandV2(
 		"puppet",
-		"Allows you to send messages through the bot",
+		"Allows you to send messages through the bot, optionally at given time",
 		"say #channel your message",
-		sendMessage)
+		origin.CommandV2("puppet", sendMessage))
+	bot.RegisterPeriodicCommandV2(
+		"puppetScheduled",
+		origin.Periodic("puppet", bot.PeriodicConfig{
+			CronSpec:  "@every 1m",
+			CmdFuncV2: deliverScheduled,
+		}))
 }
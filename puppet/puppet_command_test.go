@@ -1,14 +1,22 @@
 package puppet
 
 import (
+	"testing"
+	"time"
+
 	"github.com/go-chat-bot/bot"
 	. "github.com/smartystreets/goconvey/convey"
-	"testing"
 )
 
 func TestPuppet(t *testing.T) {
 	Convey("When say", t, func() {
-		cmd := &bot.Cmd{}
+		cmd := &bot.Cmd{User: &bot.User{Nick: "alice"}}
+		allowedNicks = []string{"alice"}
+		allowedChannels = nil
+		Reset(func() {
+			allowedNicks = nil
+			schedule.due(time.Now().AddDate(0, 0, 2))
+		})
 
 		Convey("Should return usage if less than 3 arguments", func() {
 			cmd.Args = []string{
@@ -49,5 +57,81 @@ func TestPuppet(t *testing.T) {
 			So(result.Channel, ShouldEqual, "#channel")
 			So(result.Message, ShouldEqual, "message with spaces")
 		})
+
+		Convey("Should send a CTCP action when act on IRC", func() {
+			cmd.ChannelData = &bot.ChannelData{Protocol: "irc"}
+			cmd.Args = []string{"act", "#channel", "waves"}
+			result, err := sendMessage(cmd)
+
+			So(err, ShouldBeNil)
+			So(result.Channel, ShouldEqual, "#channel")
+			So(result.Message, ShouldEqual, "\x01ACTION waves\x01")
+		})
+
+		Convey("Should send an italic message when act on other protocols", func() {
+			cmd.ChannelData = &bot.ChannelData{Protocol: "slack"}
+			cmd.Args = []string{"act", "#channel", "waves"}
+			result, err := sendMessage(cmd)
+
+			So(err, ShouldBeNil)
+			So(result.Channel, ShouldEqual, "#channel")
+			So(result.Message, ShouldEqual, "_waves_")
+		})
+
+		Convey("Should refuse nicks which are not allowed", func() {
+			cmd.User.Nick = "mallory"
+			cmd.Args = []string{"say", "#channel", "hi"}
+			result, err := sendMessage(cmd)
+
+			So(err, ShouldBeNil)
+			So(result.Channel, ShouldBeEmpty)
+			So(result.Message, ShouldEqual, notAllowed)
+		})
+
+		Convey("Should refuse channels which are not allowed", func() {
+			allowedChannels = []string{"#go-bot"}
+			cmd.Args = []string{"say", "#channel", "hi"}
+			result, err := sendMessage(cmd)
+
+			So(err, ShouldBeNil)
+			So(result.Channel, ShouldBeEmpty)
+			So(result.Message, ShouldEqual, "Puppeting into #channel is not allowed.")
+		})
+
+		Convey("Should schedule a message", func() {
+			at := time.Now().Add(2 * time.Minute).Format("15:04")
+			cmd.Args = []string{"at", at, "say", "#channel", "later"}
+			result, err := sendMessage(cmd)
+
+			So(err, ShouldBeNil)
+			So(result.Channel, ShouldBeEmpty)
+			So(result.Message, ShouldEqual, "OK, I will send it to #channel at "+at)
+
+			results, _ := deliverScheduled()
+			So(results, ShouldBeEmpty)
+			due := schedule.due(time.Now().Add(3 * time.Minute))
+			So(due, ShouldHaveLength, 1)
+			So(due[0].result, ShouldResemble, bot.CmdResult{Channel: "#channel", Message: "later"})
+		})
+
+		Convey("Should return usage if time is invalid", func() {
+			cmd.Args = []string{"at", "25:00", "say", "#channel", "later"}
+			result, err := sendMessage(cmd)
+
+			So(err, ShouldBeNil)
+			So(result.Message, ShouldEqual, seeUsage)
+		})
+	})
+
+	Convey("Next occurrence of time", t, func() {
+		now := time.Date(2020, 5, 1, 16, 30, 0, 0, time.UTC)
+
+		at, err := nextOccurrence("17:00", now)
+		So(err, ShouldBeNil)
+		So(at, ShouldEqual, time.Date(2020, 5, 1, 17, 0, 0, 0, time.UTC))
+
+		at, err = nextOccurrence("16:30", now)
+		So(err, ShouldBeNil)
+		So(at, ShouldEqual, time.Date(2020, 5, 2, 16, 30, 0, 0, time.UTC))
 	})
 }
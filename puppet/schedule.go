@@ -0,0 +1,75 @@
+package puppet
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-chat-bot/bot"
+)
+
+// scheduledMessage is sent by deliverScheduled once its time comes
+type scheduledMessage struct {
+	at      time.Time
+	nick    string
+	verb    string
+	message string // as typed, result.Message is formatted for verb
+	result  bot.CmdResult
+}
+
+// scheduleList keeps messages waiting for their time, it lives in memory
+// only so scheduled messages are lost when the bot restarts
+type scheduleList struct {
+	sync.Mutex
+	messages []scheduledMessage
+}
+
+var schedule = &scheduleList{}
+
+// nextOccurrence returns the first time after now at clock (HH:MM) in local
+// time
+func nextOccurrence(clock string, now time.Time) (time.Time, error) {
+	parsed, err := time.Parse("15:04", clock)
+	if err != nil {
+		return time.Time{}, err
+	}
+	at := time.Date(now.Year(), now.Month(), now.Day(), parsed.Hour(), parsed.Minute(),
+		0, 0, now.Location())
+	if !at.After(now) {
+		at = at.AddDate(0, 0, 1)
+	}
+	return at, nil
+}
+
+func (s *scheduleList) add(message scheduledMessage) {
+	s.Lock()
+	defer s.Unlock()
+	s.messages = append(s.messages, message)
+	sort.SliceStable(s.messages, func(i, j int) bool {
+		return s.messages[i].at.Before(s.messages[j].at)
+	})
+}
+
+// due returns and forgets messages which should have been sent by now
+func (s *scheduleList) due(now time.Time) []scheduledMessage {
+	s.Lock()
+	defer s.Unlock()
+	i := 0
+	for i < len(s.messages) && !s.messages[i].at.After(now) {
+		i++
+	}
+	due := s.messages[:i:i]
+	s.messages = s.messages[i:]
+	return due
+}
+
+func deliverScheduled() ([]bot.CmdResult, error) {
+	var results []bot.CmdResult
+	for _, message := range schedule.due(time.Now()) {
+		log.Printf("%s puppeted %s in %s (scheduled): %s", message.nick, message.verb,
+			message.result.Channel, message.message)
+		results = append(results, message.result)
+	}
+	return results, nil
+}
@@ -0,0 +1,240 @@
+package silence
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chat-bot/bot"
+	"github.com/go-chat-bot/plugins/origin"
+)
+
+const (
+	quietConfigEnv = "SILENCE_QUIET_HOURS_FILE"
+	maxDigest      = 20 // messages delivered in one digest
+	digestHeader   = "Messages from quiet hours:"
+)
+
+var (
+	weekdays = map[string]time.Weekday{
+		"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday,
+		"wed": time.Wednesday, "thu": time.Thursday, "fri": time.Friday,
+		"sat": time.Saturday,
+	}
+	quietConfigs map[string]*quietConfig // channel -> quietConfig map
+	digests      = &digestQueue{messages: make(map[string][]queuedMessage)}
+)
+
+// quietRange is a daily time range, ranges with To before From end the next
+// day
+type quietRange struct {
+	Days []string `json:"days,omitempty"` // days the range starts on (mon, tue, ...), every day if not set
+	From string   `json:"from"`           // HH:MM
+	To   string   `json:"to"`             // HH:MM, 24:00 for end of the day
+	from int      // minutes since midnight
+	to   int
+	days map[time.Weekday]bool
+}
+
+// quietConfig makes the bot quiet in a channel during some hours
+type quietConfig struct {
+	Channel  string       `json:"channel"`
+	Timezone string       `json:"timezone,omitempty"` // e.g. Europe/Prague, local time if not set
+	Hours    []quietRange `json:"hours"`
+	Except   []string     `json:"except,omitempty"` // plugins which are never quiet, e.g. cachet
+	Digest   bool         `json:"digest,omitempty"` // deliver quieted messages when quiet hours end
+	location *time.Location
+}
+
+type queuedMessage struct {
+	Sent    time.Time
+	Message string
+}
+
+// digestQueue keeps messages suppressed during quiet hours
+type digestQueue struct {
+	sync.Mutex
+	messages map[string][]queuedMessage // channel -> messages
+	dropped  map[string]int             // channel -> messages over maxDigest
+}
+
+func parseClock(clock string) (int, error) {
+	var hours, minutes int
+	if _, err := fmt.Sscanf(clock, "%d:%d", &hours, &minutes); err != nil {
+		return 0, fmt.Errorf("invalid time %q, expecting HH:MM", clock)
+	}
+	total := hours*60 + minutes
+	if hours < 0 || minutes < 0 || minutes > 59 || total > 24*60 {
+		return 0, fmt.Errorf("invalid time %q, expecting HH:MM", clock)
+	}
+	return total, nil
+}
+
+func (r *quietRange) parse() error {
+	var err error
+	if r.from, err = parseClock(r.From); err != nil {
+		return err
+	}
+	if r.to, err = parseClock(r.To); err != nil {
+		return err
+	}
+	if len(r.Days) == 0 {
+		return nil
+	}
+	r.days = make(map[time.Weekday]bool)
+	for _, day := range r.Days {
+		weekday, found := weekdays[strings.ToLower(day)]
+		if !found {
+			return fmt.Errorf("invalid day %q", day)
+		}
+		r.days[weekday] = true
+	}
+	return nil
+}
+
+func (r *quietRange) startsOn(day time.Weekday) bool {
+	return r.days == nil || r.days[day]
+}
+
+// contains reports whether local time t falls into the range
+func (r *quietRange) contains(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	today := t.Weekday()
+	if r.from < r.to {
+		return r.startsOn(today) && r.from <= minute && minute < r.to
+	}
+	yesterday := (today + 6) % 7
+	return (r.startsOn(today) && minute >= r.from) ||
+		(r.startsOn(yesterday) && minute < r.to)
+}
+
+// quiet reports whether t falls into quiet hours of the channel
+func (config *quietConfig) quiet(t time.Time) bool {
+	if config == nil {
+		return false
+	}
+	t = t.In(config.location)
+	for i := range config.Hours {
+		if config.Hours[i].contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// quietFor reports whether message of plugin sent to channel at t should wait
+// for the end of quiet hours
+func quietFor(channel, plugin string, t time.Time) bool {
+	config := quietConfigs[channel]
+	return config.quiet(t) && !contains(config.Except, plugin)
+}
+
+func (q *digestQueue) add(channel, message string, sent time.Time) {
+	q.Lock()
+	defer q.Unlock()
+	if len(q.messages[channel]) >= maxDigest {
+		if q.dropped == nil {
+			q.dropped = make(map[string]int)
+		}
+		q.dropped[channel]++
+		return
+	}
+	q.messages[channel] = append(q.messages[channel], queuedMessage{sent, message})
+}
+
+// take returns and forgets queued messages of channels for which ready
+// returns true and number of messages which didn't fit into the digest
+func (q *digestQueue) take(ready func(channel string) bool) (map[string][]queuedMessage, map[string]int) {
+	q.Lock()
+	defer q.Unlock()
+	messages := make(map[string][]queuedMessage)
+	dropped := make(map[string]int)
+	for channel, queued := range q.messages {
+		if !ready(channel) {
+			continue
+		}
+		messages[channel] = queued
+		dropped[channel] = q.dropped[channel]
+		delete(q.messages, channel)
+		delete(q.dropped, channel)
+	}
+	return messages, dropped
+}
+
+// deliverDigests sends messages queued during quiet hours to channels whose
+// quiet hours have ended. Digests of silenced channels wait for the end of
+// the silence.
+func deliverDigests() ([]bot.CmdResult, error) {
+	now := time.Now()
+	messages, dropped := digests.take(func(channel string) bool {
+		return !quietConfigs[channel].quiet(now) &&
+			!store.muted(channel, pluginName, origin.KindPeriodic, now)
+	})
+	var results []bot.CmdResult
+	for channel, queued := range messages {
+		location := time.Local
+		if config := quietConfigs[channel]; config != nil {
+			location = config.location
+		}
+		results = append(results, bot.CmdResult{Channel: channel, Message: digestHeader})
+		for _, msg := range queued {
+			results = append(results, bot.CmdResult{
+				Channel: channel,
+				Message: fmt.Sprintf("[%s] %s", msg.Sent.In(location).Format("15:04"), msg.Message),
+			})
+		}
+		if dropped[channel] > 0 {
+			results = append(results, bot.CmdResult{
+				Channel: channel,
+				Message: fmt.Sprintf("... and %d more", dropped[channel]),
+			})
+		}
+	}
+	return results, nil
+}
+
+func loadQuietConfigs(filename string) error {
+	quietConfigs = make(map[string]*quietConfig)
+
+	file, err := os.Open(filename)
+	if err != nil {
+		log.Printf("Failed opening configuration file %s: %v\n", filename, err)
+		return err
+	}
+	defer file.Close()
+	configs := make([]quietConfig, 0)
+	err = json.NewDecoder(file).Decode(&configs)
+	if err != nil {
+		log.Printf("Error loading configuration: %v\n", err)
+		return err
+	}
+	for i, config := range configs {
+		if config.Channel == "" {
+			log.Println("Configuration without channel found. Skipping")
+			continue
+		}
+		location := time.Local
+		if config.Timezone != "" {
+			if location, err = time.LoadLocation(config.Timezone); err != nil {
+				log.Printf("Invalid timezone of %s: %v. Skipping", config.Channel, err)
+				continue
+			}
+		}
+		configs[i].location = location
+		valid := true
+		for j := range configs[i].Hours {
+			if err := configs[i].Hours[j].parse(); err != nil {
+				log.Printf("Invalid quiet hours of %s: %v. Skipping", config.Channel, err)
+				valid = false
+			}
+		}
+		if valid {
+			quietConfigs[config.Channel] = &configs[i]
+		}
+	}
+	return nil
+}
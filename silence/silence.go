@@ -3,55 +3,185 @@ package silence
 import (
 	"fmt"
 	"github.com/go-chat-bot/bot"
+	"github.com/go-chat-bot/plugins/origin"
 	"log"
+	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
+const (
+	silenceFileEnv = "SILENCE_FILE"
+	pluginName     = "silence"
+	usage          = "Usage: !silence <minutes> [plugins | passive | command | periodic | except plugins] or !silence status"
+)
+
 var (
-	silentMap map[string]time.Time
+	store = &silenceStore{mutes: make(map[string][]mute)}
+	kinds = map[string]bool{
+		origin.KindPassive:  true,
+		origin.KindCommand:  true,
+		origin.KindPeriodic: true,
+	}
 )
 
 func silenceFilter(cmd *bot.FilterCmd) (string, error) {
-	until, found := silentMap[cmd.Target]
-	if !found || time.Now().After(until) {
+	plugin := origin.Lookup(cmd)
+	if plugin == pluginName && origin.LookupKind(cmd) == origin.KindCommand {
+		// replies to silence commands always go out, e.g. the confirmation
 		return cmd.Message, nil
 	}
-	log.Printf("Silencing message in %s\n", cmd.Target)
-	return "", nil
+	now := time.Now()
+	if store.muted(cmd.Target, plugin, origin.LookupKind(cmd), now) {
+		log.Printf("Silencing message in %s\n", cmd.Target)
+		return "", nil
+	}
+	if quietFor(cmd.Target, plugin, now) {
+		if quietConfigs[cmd.Target].Digest {
+			digests.add(cmd.Target, cmd.Message, now)
+		}
+		log.Printf("Quiet hours, holding back message in %s\n", cmd.Target)
+		return "", nil
+	}
+	return cmd.Message, nil
+}
+
+// describe returns what the mute silences, e.g. "catfacts, passive messages"
+func describe(m mute) string {
+	if len(m.Plugins) == 0 && len(m.Kinds) == 0 {
+		if len(m.Except) > 0 {
+			return "everything except " + strings.Join(m.Except, ", ")
+		}
+		return "everything"
+	}
+	names := append([]string{}, m.Plugins...)
+	for _, kind := range m.Kinds {
+		names = append(names, kind+" messages")
+	}
+	return strings.Join(names, ", ")
+}
+
+// formatRemaining formats time left in whole minutes, e.g. 1h5m
+func formatRemaining(remaining time.Duration) string {
+	minutes := int((remaining + time.Minute - 1) / time.Minute)
+	switch {
+	case minutes < 60:
+		return fmt.Sprintf("%dm", minutes)
+	case minutes%60 == 0:
+		return fmt.Sprintf("%dh", minutes/60)
+	default:
+		return fmt.Sprintf("%dh%dm", minutes/60, minutes%60)
+	}
+}
+
+func status(channel string) string {
+	now := time.Now()
+	mutes := store.active(channel, now)
+	var lines []string
+	if config := quietConfigs[channel]; config.quiet(now) {
+		lines = append(lines, "Quiet hours")
+	}
+	if len(mutes) == 0 && len(lines) == 0 {
+		return "I am not silent here"
+	}
+	for _, m := range mutes {
+		lines = append(lines, fmt.Sprintf("Silencing %s for %s", describe(m),
+			formatRemaining(m.Until.Sub(now))))
+	}
+	return strings.Join(lines, "; ")
+}
+
+// parseSelection fills plugins, kinds or exceptions of m from command
+// arguments, e.g. "catfacts,chucknorris", "passive" or "except cachet,jira"
+func parseSelection(m *mute, args []string) bool {
+	var names []string
+	for _, name := range strings.Split(strings.Join(args, ","), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	if len(names) > 0 && names[0] == "except" {
+		m.Except = names[1:]
+		return len(m.Except) > 0
+	}
+	for _, name := range names {
+		if kinds[name] {
+			m.Kinds = append(m.Kinds, name)
+		} else {
+			m.Plugins = append(m.Plugins, name)
+		}
+	}
+	return true
 }
 
 func silence(cmd *bot.Cmd) (string, error) {
-	if len(cmd.Args) != 1 {
-		return "Argument must be exactly 1 number (of minutes to be silent)", nil
+	if len(cmd.Args) == 1 && cmd.Args[0] == "status" {
+		return status(cmd.Channel), nil
+	}
+	if len(cmd.Args) == 0 {
+		return usage, nil
 	}
 
 	min, err := strconv.Atoi(cmd.Args[0])
+	if err != nil || min < 0 {
+		return usage, nil
+	}
+	now := time.Now().UTC()
+	m := mute{Until: now.Add(time.Duration(min) * time.Minute)}
+	if !parseSelection(&m, cmd.Args[1:]) {
+		return usage, nil
+	}
+
+	if min == 0 && len(cmd.Args) == 1 {
+		err = store.clear(cmd.Channel, now)
+	} else {
+		err = store.add(cmd.Channel, m, now)
+	}
 	if err != nil {
-		return "Argument must be exactly 1 number (of minutes to be silent)!", nil
-	}
-	until := time.Now().UTC().Add(time.Duration(min) * time.Minute)
-
-	go func() {
-		// delay setting the timeout so plugin reply can go out first
-		time.Sleep(1 * time.Second)
-		silentMap[cmd.Channel] = until
-	}()
-	// disable map for plugin reply
-	silentMap[cmd.Channel] = time.Now()
-	return fmt.Sprintf("OK, I will be silent until %s\n",
-		until.Format(time.RFC1123)), nil
+		log.Printf("Failed to save silenced channels: %v", err)
+	}
+
+	switch {
+	case min == 0 && len(cmd.Args) == 1:
+		return "OK, I am not silent anymore", nil
+	case min == 0:
+		return fmt.Sprintf("OK, I am not silencing %s anymore", describe(m)), nil
+	case describe(m) == "everything":
+		return fmt.Sprintf("OK, I will be silent until %s",
+			m.Until.Format(time.RFC1123)), nil
+	}
+	return fmt.Sprintf("OK, I will silence %s until %s", describe(m),
+		m.Until.Format(time.RFC1123)), nil
 }
 
 func init() {
-	silentMap = make(map[string]time.Time)
+	var err error
+	store, err = newSilenceStore(os.Getenv(silenceFileEnv))
+	if err != nil {
+		log.Printf("Failed to load silenced channels: %v", err)
+	}
+
 	bot.RegisterFilterCommand(
 		"silence",
 		silenceFilter)
 
 	bot.RegisterCommand(
 		"silence",
-		"Makes the bot completely silent for X minutes (0 removes silence)",
-		"5",
-		silence)
+		"Makes the bot silent for X minutes (0 removes silence), optionally only for some plugins or kinds of messages. 'status' lists active silences",
+		"30 catfacts,chucknorris",
+		origin.Command(pluginName, silence))
+
+	if filename := os.Getenv(quietConfigEnv); filename != "" {
+		if err := loadQuietConfigs(filename); err != nil {
+			log.Printf("Failed to load quiet hours, the bot won't be quiet: %v", err)
+			return
+		}
+		bot.RegisterPeriodicCommandV2(
+			"silenceQuietHoursDigest",
+			origin.Periodic(pluginName, bot.PeriodicConfig{
+				CronSpec:  "@every 1m",
+				CmdFuncV2: deliverDigests,
+			}))
+	}
 }
@@ -0,0 +1,300 @@
+package silence
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-chat-bot/bot"
+	"github.com/go-chat-bot/plugins/origin"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSilence(t *testing.T) {
+	Convey("Given a silence command", t, func() {
+		store, _ = newSilenceStore("")
+		origin.Clear()
+		filter := func(message string) string {
+			filtered, err := silenceFilter(&bot.FilterCmd{Target: "#go", Message: message})
+			So(err, ShouldBeNil)
+			return filtered
+		}
+		filterFrom := func(plugin, kind, message string) string {
+			var reply string
+			switch kind {
+			case origin.KindPassive:
+				reply, _ = origin.Passive(plugin, func(*bot.PassiveCmd) (string, error) {
+					return message, nil
+				})(&bot.PassiveCmd{Channel: "#go"})
+			default:
+				reply, _ = origin.Command(plugin, func(*bot.Cmd) (string, error) {
+					return message, nil
+				})(&bot.Cmd{Channel: "#go"})
+			}
+			filtered, err := silenceFilter(&bot.FilterCmd{Target: "#go", Message: reply})
+			So(err, ShouldBeNil)
+			return filtered
+		}
+		silenceFor := func(args ...string) (string, error) {
+			return origin.Command(pluginName, silence)(&bot.Cmd{Channel: "#go", Args: args})
+		}
+
+		Convey("Messages go out when not silent", func() {
+			So(filter("hello"), ShouldEqual, "hello")
+		})
+
+		Convey("Messages are dropped while silent", func() {
+			reply, err := silenceFor("5")
+
+			So(err, ShouldBeNil)
+			So(reply, ShouldStartWith, "OK, I will be silent until")
+			So(filter("hello"), ShouldEqual, "")
+			So(silentIn("#other"), ShouldBeFalse)
+		})
+
+		Convey("Confirmation goes out right away", func() {
+			reply, _ := silenceFor("5")
+
+			So(filter(reply), ShouldEqual, reply)
+		})
+
+		Convey("Zero removes silence", func() {
+			silenceFor("5")
+			reply, _ := silenceFor("0")
+
+			So(reply, ShouldEqual, "OK, I am not silent anymore")
+			So(filter("hello"), ShouldEqual, "hello")
+		})
+
+		Convey("Invalid arguments are reported", func() {
+			reply, _ := silenceFor()
+			So(reply, ShouldEqual, usage)
+			reply, _ = silenceFor("-1")
+			So(reply, ShouldEqual, usage)
+			reply, _ = silenceFor("soon")
+			So(reply, ShouldEqual, usage)
+			reply, _ = silenceFor("5", "except")
+			So(reply, ShouldEqual, usage)
+		})
+
+		Convey("Only listed plugins are muted", func() {
+			reply, _ := silenceFor("30", "catfacts,chucknorris")
+
+			So(reply, ShouldStartWith, "OK, I will silence catfacts, chucknorris until")
+			So(filterFrom("catfacts", origin.KindPassive, "meow"), ShouldEqual, "")
+			So(filterFrom("chucknorris", origin.KindPassive, "roundhouse"), ShouldEqual, "")
+			So(filterFrom("cachet", origin.KindPassive, "outage"), ShouldEqual, "outage")
+			So(filter("unknown"), ShouldEqual, "unknown")
+		})
+
+		Convey("Same text of another plugin is not muted", func() {
+			silenceFor("30", "catfacts")
+			origin.Record("catfacts", "#go", "meow")
+			origin.Record("cachet", "#go", "meow")
+
+			So(filter("meow"), ShouldEqual, "")
+			So(filter("meow"), ShouldEqual, "meow")
+		})
+
+		Convey("Kinds of messages can be muted", func() {
+			reply, _ := silenceFor("30", "passive")
+
+			So(reply, ShouldStartWith, "OK, I will silence passive messages until")
+			So(filterFrom("catfacts", origin.KindPassive, "meow"), ShouldEqual, "")
+			So(filterFrom("gif", origin.KindCommand, "gif"), ShouldEqual, "gif")
+		})
+
+		Convey("Plugins can be excepted", func() {
+			reply, _ := silenceFor("30", "except", "cachet,jira")
+
+			So(reply, ShouldStartWith, "OK, I will silence everything except cachet, jira until")
+			So(filterFrom("cachet", origin.KindPassive, "outage"), ShouldEqual, "outage")
+			So(filterFrom("catfacts", origin.KindPassive, "meow"), ShouldEqual, "")
+			So(filter("unknown"), ShouldEqual, "")
+		})
+
+		Convey("Zero removes only the given selection", func() {
+			silenceFor("30", "catfacts")
+			silenceFor("30", "chucknorris")
+
+			reply, _ := silenceFor("0", "catfacts")
+
+			So(reply, ShouldEqual, "OK, I am not silencing catfacts anymore")
+			So(filterFrom("catfacts", origin.KindPassive, "meow"), ShouldEqual, "meow")
+			So(filterFrom("chucknorris", origin.KindPassive, "roundhouse"), ShouldEqual, "")
+		})
+
+		Convey("Status lists active mutes", func() {
+			reply, _ := silenceFor("status")
+			So(reply, ShouldEqual, "I am not silent here")
+
+			silenceFor("30", "catfacts")
+			silenceFor("90", "except", "cachet")
+			reply, _ = silenceFor("status")
+
+			So(reply, ShouldEqual, "Silencing catfacts for 30m; Silencing everything except cachet for 1h30m")
+		})
+	})
+
+	Convey("Given a silence file", t, func() {
+		dir, _ := ioutil.TempDir("", "silence")
+		Reset(func() {
+			os.RemoveAll(dir)
+		})
+		file := filepath.Join(dir, "silence.json")
+		now := time.Now()
+
+		Convey("Silence survives restart", func() {
+			s, err := newSilenceStore(file)
+			So(err, ShouldBeNil)
+			So(s.add("#go", mute{Until: now.Add(time.Hour), Plugins: []string{"catfacts"}}, now), ShouldBeNil)
+
+			reloaded, err := newSilenceStore(file)
+
+			So(err, ShouldBeNil)
+			So(reloaded.muted("#go", "catfacts", "", now), ShouldBeTrue)
+			So(reloaded.muted("#go", "cachet", "", now), ShouldBeFalse)
+			So(reloaded.muted("#go", "catfacts", "", now.Add(2*time.Hour)), ShouldBeFalse)
+		})
+
+		Convey("Expired silence is not saved", func() {
+			s, _ := newSilenceStore(file)
+			s.add("#old", mute{Until: now.Add(time.Minute)}, now)
+			s.add("#go", mute{Until: now.Add(time.Hour)}, now.Add(time.Minute))
+
+			reloaded, _ := newSilenceStore(file)
+
+			So(reloaded.mutes, ShouldContainKey, "#go")
+			So(reloaded.mutes, ShouldNotContainKey, "#old")
+		})
+	})
+}
+
+func silentIn(channel string) bool {
+	return store.muted(channel, "", "", time.Now())
+}
+
+func TestQuietHours(t *testing.T) {
+	Convey("Given quiet hours configuration", t, func() {
+		dir, _ := ioutil.TempDir("", "silence")
+		Reset(func() {
+			os.RemoveAll(dir)
+			quietConfigs = nil
+			digests = &digestQueue{messages: make(map[string][]queuedMessage)}
+			store, _ = newSilenceStore("")
+			origin.Clear()
+		})
+		file := filepath.Join(dir, "quiet.json")
+		ioutil.WriteFile(file, []byte(`[
+			{
+				"channel": "#general",
+				"timezone": "Europe/Prague",
+				"hours": [
+					{"days": ["mon", "tue", "wed", "thu", "fri"], "from": "00:00", "to": "09:00"},
+					{"days": ["mon", "tue", "wed", "thu", "fri"], "from": "18:00", "to": "24:00"},
+					{"days": ["sat", "sun"], "from": "00:00", "to": "24:00"},
+					{"days": ["wed"], "from": "23:00", "to": "01:00"}
+				],
+				"except": ["cachet"],
+				"digest": true
+			},
+			{"channel": "#bad", "timezone": "Mars/Olympus", "hours": []},
+			{"channel": "#worse", "hours": [{"from": "25:00", "to": "09:00"}]}
+		]`), 0600)
+		So(loadQuietConfigs(file), ShouldBeNil)
+		So(quietConfigs, ShouldContainKey, "#general")
+		So(quietConfigs, ShouldNotContainKey, "#bad")
+		So(quietConfigs, ShouldNotContainKey, "#worse")
+		prague, _ := time.LoadLocation("Europe/Prague")
+		config := quietConfigs["#general"]
+
+		Convey("Working hours are not quiet", func() {
+			So(config.quiet(time.Date(2019, 1, 2, 10, 0, 0, 0, prague)), ShouldBeFalse)
+			So(config.quiet(time.Date(2019, 1, 2, 17, 59, 0, 0, prague)), ShouldBeFalse)
+		})
+
+		Convey("Evenings and nights are quiet", func() {
+			So(config.quiet(time.Date(2019, 1, 2, 18, 0, 0, 0, prague)), ShouldBeTrue)
+			So(config.quiet(time.Date(2019, 1, 3, 8, 59, 0, 0, prague)), ShouldBeTrue)
+		})
+
+		Convey("Weekends are quiet", func() {
+			So(config.quiet(time.Date(2019, 1, 5, 12, 0, 0, 0, prague)), ShouldBeTrue)
+			So(config.quiet(time.Date(2019, 1, 7, 0, 30, 0, 0, prague)), ShouldBeTrue)
+		})
+
+		Convey("Ranges can continue past midnight", func() {
+			config.Hours = config.Hours[3:]
+
+			So(config.quiet(time.Date(2019, 1, 2, 23, 30, 0, 0, prague)), ShouldBeTrue)
+			So(config.quiet(time.Date(2019, 1, 3, 0, 30, 0, 0, prague)), ShouldBeTrue)
+			So(config.quiet(time.Date(2019, 1, 3, 23, 30, 0, 0, prague)), ShouldBeFalse)
+			So(config.quiet(time.Date(2019, 1, 4, 0, 30, 0, 0, prague)), ShouldBeFalse)
+		})
+
+		Convey("Timezone of the channel is used", func() {
+			So(config.quiet(time.Date(2019, 1, 2, 10, 0, 0, 0, time.UTC)), ShouldBeFalse)
+			So(config.quiet(time.Date(2019, 1, 2, 17, 30, 0, 0, time.UTC)), ShouldBeTrue)
+		})
+
+		Convey("Excepted plugins are never quiet", func() {
+			night := time.Date(2019, 1, 2, 22, 0, 0, 0, prague)
+
+			So(quietFor("#general", "cachet", night), ShouldBeFalse)
+			So(quietFor("#general", "catfacts", night), ShouldBeTrue)
+			So(quietFor("#other", "catfacts", night), ShouldBeFalse)
+		})
+
+		Convey("Held back messages are delivered in a digest", func() {
+			night := time.Date(2019, 1, 2, 22, 5, 0, 0, prague)
+			digests.add("#general", "meow", night)
+			config.Hours = nil
+
+			results, err := deliverDigests()
+
+			So(err, ShouldBeNil)
+			So(results, ShouldResemble, []bot.CmdResult{
+				{Channel: "#general", Message: digestHeader},
+				{Channel: "#general", Message: "[22:05] meow"},
+			})
+			results, _ = deliverDigests()
+			So(results, ShouldBeEmpty)
+		})
+
+		Convey("Digest waits while the channel is silenced", func() {
+			now := time.Now()
+			store.add("#general", mute{Until: now.Add(time.Hour)}, now)
+			digests.add("#general", "meow", now)
+			config.Hours = nil
+			deliver := origin.Periodic(pluginName, bot.PeriodicConfig{CmdFuncV2: deliverDigests}).CmdFuncV2
+
+			results, err := deliver()
+
+			So(err, ShouldBeNil)
+			So(results, ShouldBeEmpty)
+
+			store.clear("#general", now)
+			results, _ = deliver()
+
+			So(results, ShouldHaveLength, 2)
+			store.add("#general", mute{Until: now.Add(time.Hour)}, now)
+			filtered, err := silenceFilter(&bot.FilterCmd{Target: "#general", Message: results[1].Message})
+			So(err, ShouldBeNil)
+			So(filtered, ShouldEqual, "")
+		})
+
+		Convey("Digest is capped", func() {
+			for i := 0; i < maxDigest+3; i++ {
+				digests.add("#general", "meow", time.Now())
+			}
+			config.Hours = nil
+
+			results, _ := deliverDigests()
+
+			So(results, ShouldHaveLength, maxDigest+2)
+			So(results[len(results)-1].Message, ShouldEqual, "... and 3 more")
+		})
+	})
+}
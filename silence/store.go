@@ -0,0 +1,170 @@
+package silence
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// mute silences messages in a channel until given time. Without any plugins
+// or kinds everything except plugins listed in Except is muted.
+type mute struct {
+	Until   time.Time `json:"until"`
+	Plugins []string  `json:"plugins,omitempty"` // muted plugins
+	Kinds   []string  `json:"kinds,omitempty"`   // muted kinds of messages, e.g. passive
+	Except  []string  `json:"except,omitempty"`  // plugins which are never muted
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// mutes reports whether message produced by plugin of given kind is muted,
+// both can be empty when not known
+func (m mute) mutes(plugin, kind string) bool {
+	if len(m.Plugins) == 0 && len(m.Kinds) == 0 {
+		return !contains(m.Except, plugin)
+	}
+	return (plugin != "" && contains(m.Plugins, plugin)) ||
+		(kind != "" && contains(m.Kinds, kind))
+}
+
+func (m mute) sameSelection(other mute) bool {
+	return reflect.DeepEqual(m.Plugins, other.Plugins) &&
+		reflect.DeepEqual(m.Kinds, other.Kinds) &&
+		reflect.DeepEqual(m.Except, other.Except)
+}
+
+// silenceStore keeps mutes of channels. If file is set they are also saved
+// there so that silence survives restarts.
+type silenceStore struct {
+	sync.Mutex
+	file  string
+	mutes map[string][]mute // channel -> active mutes
+}
+
+func newSilenceStore(file string) (*silenceStore, error) {
+	store := &silenceStore{file: file, mutes: make(map[string][]mute)}
+	if file == "" {
+		return store, nil
+	}
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return store, err
+	}
+	err = json.Unmarshal(data, &store.mutes)
+	if store.mutes == nil {
+		store.mutes = make(map[string][]mute)
+	}
+	return store, err
+}
+
+// save writes the mutes to file, replacing it atomically. Caller must hold
+// the lock.
+func (s *silenceStore) save() error {
+	if s.file == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(s.mutes, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(s.file), ".silence")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.file)
+}
+
+// expire removes mutes ended before now. Caller must hold the lock.
+func (s *silenceStore) expire(now time.Time) {
+	for channel, mutes := range s.mutes {
+		active := mutes[:0]
+		for _, m := range mutes {
+			if now.Before(m.Until) {
+				active = append(active, m)
+			}
+		}
+		if len(active) == 0 {
+			delete(s.mutes, channel)
+		} else {
+			s.mutes[channel] = active
+		}
+	}
+}
+
+// add mutes channel, replacing previous mute of the same plugins or kinds
+func (s *silenceStore) add(channel string, m mute, now time.Time) error {
+	s.Lock()
+	defer s.Unlock()
+	s.expire(now)
+	mutes := make([]mute, 0, len(s.mutes[channel])+1)
+	for _, other := range s.mutes[channel] {
+		if !other.sameSelection(m) {
+			mutes = append(mutes, other)
+		}
+	}
+	if m.Until.After(now) {
+		mutes = append(mutes, m)
+	}
+	s.mutes[channel] = mutes
+	if len(mutes) == 0 {
+		delete(s.mutes, channel)
+	}
+	return s.save()
+}
+
+// clear removes all mutes of channel
+func (s *silenceStore) clear(channel string, now time.Time) error {
+	s.Lock()
+	defer s.Unlock()
+	s.expire(now)
+	delete(s.mutes, channel)
+	return s.save()
+}
+
+// muted reports whether message produced by plugin of given kind is muted in
+// channel at now
+func (s *silenceStore) muted(channel, plugin, kind string, now time.Time) bool {
+	s.Lock()
+	defer s.Unlock()
+	for _, m := range s.mutes[channel] {
+		if now.Before(m.Until) && m.mutes(plugin, kind) {
+			return true
+		}
+	}
+	return false
+}
+
+// active returns mutes of channel active at now
+func (s *silenceStore) active(channel string, now time.Time) []mute {
+	s.Lock()
+	defer s.Unlock()
+	var active []mute
+	for _, m := range s.mutes[channel] {
+		if now.Before(m.Until) {
+			active = append(active, m)
+		}
+	}
+	return active
+}
@@ -5,6 +5,7 @@ import (
 	"strings"
 
 	"github.com/go-chat-bot/bot"
+	"github.com/go-chat-bot/plugins/origin"
 )
 
 const (
@@ -129,5 +130,5 @@ func init() {
 		"treta",
 		"sowing discord",
 		"",
-		treta)
+		origin.Command("treta", treta))
 }
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"github.com/dghubble/go-twitter/twitter"
 	"github.com/go-chat-bot/bot"
+	"github.com/go-chat-bot/plugins/origin"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/clientcredentials"
 	"os"
@@ -211,5 +212,5 @@ func expandTweets(cmd *bot.PassiveCmd) (string, error) {
 func init() {
 	bot.RegisterPassiveCommand(
 		"twitter",
-		expandTweets)
+		origin.Passive("twitter", expandTweets))
 }
@@ -7,6 +7,7 @@ import (
 	"github.com/cloudfoundry/gosigar"
 	"time"
 	"github.com/go-chat-bot/bot"
+	"github.com/go-chat-bot/plugins/origin"
 )
 
 func uptime(command *bot.Cmd) (msg string, err error) {
@@ -23,5 +24,5 @@ func init() {
 		"uptime",
 		"Sends the uptime of your server to you on the channel.",
 		"",
-		uptime)
+		origin.Command("uptime", uptime))
 }
@@ -0,0 +1,141 @@
+package url
+
+import (
+	"container/list"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	cacheSizeEnv     = "URL_CACHE_SIZE"
+	cacheTTLEnv      = "URL_CACHE_TTL"
+	defaultCacheSize = 256
+	defaultCacheTTL  = 60 // minutes
+)
+
+var (
+	// trackingParams are removed from query when normalizing URLs
+	trackingParams = map[string]bool{
+		"fbclid":  true,
+		"gclid":   true,
+		"dclid":   true,
+		"msclkid": true,
+		"mc_cid":  true,
+		"mc_eid":  true,
+		"igshid":  true,
+		"ref_src": true,
+		"_hsenc":  true,
+		"_hsmi":   true,
+	}
+	trackingPrefixes = []string{"utm_"}
+
+	descriptions = newDescriptionCache(defaultCacheSize,
+		defaultCacheTTL*time.Minute)
+)
+
+func isTrackingParam(name string) bool {
+	name = strings.ToLower(name)
+	if trackingParams[name] {
+		return true
+	}
+	for _, prefix := range trackingPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeURL returns canonical form of URL used as a key for cache and link
+// history: lower case scheme and host, no default port, no fragment, no
+// tracking parameters and sorted query
+func normalizeURL(URL *url.URL) string {
+	normalized := *URL
+	normalized.Scheme = strings.ToLower(URL.Scheme)
+	normalized.Host = strings.ToLower(URL.Host)
+	if port := URL.Port(); (port == "80" && normalized.Scheme == "http") ||
+		(port == "443" && normalized.Scheme == "https") {
+		normalized.Host = strings.TrimSuffix(normalized.Host, ":"+port)
+	}
+	normalized.Fragment = ""
+	normalized.RawFragment = ""
+	if normalized.Path == "/" {
+		normalized.Path = ""
+		normalized.RawPath = ""
+	}
+
+	query := URL.Query()
+	for name := range query {
+		if isTrackingParam(name) {
+			query.Del(name)
+		}
+	}
+	// Encode sorts parameters by name
+	normalized.RawQuery = query.Encode()
+	return normalized.String()
+}
+
+type cacheEntry struct {
+	key         string
+	description string
+	expires     time.Time
+}
+
+// descriptionCache is a LRU cache of URL descriptions whose entries also
+// expire after a fixed time
+type descriptionCache struct {
+	sync.Mutex
+	size    int
+	ttl     time.Duration
+	entries map[string]*list.Element
+	order   *list.List // most recently used at front
+}
+
+func newDescriptionCache(size int, ttl time.Duration) *descriptionCache {
+	return &descriptionCache{
+		size:    size,
+		ttl:     ttl,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+func (c *descriptionCache) get(key string) (string, bool) {
+	c.Lock()
+	defer c.Unlock()
+	element, found := c.entries[key]
+	if !found {
+		return "", false
+	}
+	entry := element.Value.(*cacheEntry)
+	if time.Now().After(entry.expires) {
+		c.order.Remove(element)
+		delete(c.entries, key)
+		return "", false
+	}
+	c.order.MoveToFront(element)
+	return entry.description, true
+}
+
+func (c *descriptionCache) put(key, description string) {
+	if c.size <= 0 {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	expires := time.Now().Add(c.ttl)
+	if element, found := c.entries[key]; found {
+		entry := element.Value.(*cacheEntry)
+		entry.description, entry.expires = description, expires
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&cacheEntry{key, description, expires})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
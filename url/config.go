@@ -0,0 +1,106 @@
+package url
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+)
+
+const (
+	channelConfigEnv   = "URL_CONFIG_FILE"
+	internalDomainsEnv = "URL_INTERNAL_DOMAINS"
+	defaultChannel     = "*"
+)
+
+// channelConfig limits which URLs are described in a channel
+type channelConfig struct {
+	Channel      string   `json:"channel"`                // channel name or * for all channels without own config
+	Allow        []string `json:"allow,omitempty"`        // if set, only these domains (and subdomains) are described
+	Deny         []string `json:"deny,omitempty"`         // domains (and subdomains) which are never described
+	ExternalOnly bool     `json:"externalOnly,omitempty"` // skip internal hosts (see isInternalHost)
+}
+
+var (
+	channelConfigs  map[string]*channelConfig // channel -> channelConfig map
+	internalDomains = []string{"localhost", "local", "internal", "home.arpa"}
+)
+
+func matchesAny(host string, domains []string) bool {
+	for _, domain := range domains {
+		if hostMatches(host, strings.ToLower(domain)) {
+			return true
+		}
+	}
+	return false
+}
+
+// isInternalHost reports whether host looks like it belongs to a private
+// network: non-public IP address, single label name (e.g. "jenkins") or name
+// under one of internal domains
+func isInternalHost(host string) bool {
+	if ip := net.ParseIP(host); ip != nil {
+		return !isPublicIP(ip)
+	}
+	host = strings.TrimSuffix(host, ".")
+	return !strings.Contains(host, ".") || matchesAny(host, internalDomains)
+}
+
+// allowed reports whether URL can be fetched under the configuration. Nil
+// configuration allows everything.
+func (config *channelConfig) allowed(URL *url.URL) bool {
+	if config == nil {
+		return true
+	}
+	host := strings.ToLower(URL.Hostname())
+	if matchesAny(host, config.Deny) {
+		return false
+	}
+	if len(config.Allow) > 0 && !matchesAny(host, config.Allow) {
+		return false
+	}
+	return !(config.ExternalOnly && isInternalHost(host))
+}
+
+// name identifies the configuration, channels using the same one can share
+// descriptions of URLs
+func (config *channelConfig) name() string {
+	if config == nil {
+		return ""
+	}
+	return config.Channel
+}
+
+func getChannelConfig(channel string) *channelConfig {
+	if config, found := channelConfigs[channel]; found {
+		return config
+	}
+	return channelConfigs[defaultChannel]
+}
+
+func loadChannelConfigs(filename string) error {
+	channelConfigs = make(map[string]*channelConfig)
+
+	file, err := os.Open(filename)
+	if err != nil {
+		log.Printf("Failed opening configuration file %s: %v\n", filename, err)
+		return err
+	}
+	defer file.Close()
+	configs := make([]channelConfig, 0)
+	err = json.NewDecoder(file).Decode(&configs)
+	if err != nil {
+		log.Printf("Error loading configuration: %v\n", err)
+		return err
+	}
+	for i, config := range configs {
+		if config.Channel == "" {
+			log.Println("Configuration without channel found. Skipping")
+			continue
+		}
+		channelConfigs[config.Channel] = &configs[i]
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package url
+
+import (
+	"context"
+	"fmt"
+	"image"
+	_ "image/gif" // register decoders for image dimensions
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	maxBytesEnv      = "URL_MAX_BYTES"
+	describeFilesEnv = "URL_DESCRIBE_FILES"
+	defaultMaxBytes  = 512 * 1024
+	maxImageBytes    = 64 * 1024 // enough to find dimensions in image header
+	fetchTimeout     = 10 * time.Second
+)
+
+var (
+	httpClient           = newGuardedClient()
+	maxBytes       int64 = defaultMaxBytes
+	describeFiles  bool
+	mediaTypeNames = map[string]string{
+		"application/pdf":  "PDF",
+		"application/zip":  "ZIP archive",
+		"application/gzip": "gzip archive",
+	}
+)
+
+// resourceInfo describes the resource behind URL based on response headers
+type resourceInfo struct {
+	ContentType string // raw Content-Type header including parameters
+	MediaType   string // media type without parameters, empty when unknown
+	Size        int64  // total size in bytes, -1 when unknown
+}
+
+func (info resourceInfo) isHTML() bool {
+	// pages without content type are worth a try
+	return info.MediaType == "" ||
+		info.MediaType == "text/html" ||
+		info.MediaType == "application/xhtml+xml"
+}
+
+func (info resourceInfo) isImage() bool {
+	return strings.HasPrefix(info.MediaType, "image/")
+}
+
+func infoFromResponse(res *http.Response) resourceInfo {
+	info := resourceInfo{
+		ContentType: res.Header.Get("Content-Type"),
+		Size:        -1,
+	}
+	info.MediaType, _, _ = mime.ParseMediaType(info.ContentType)
+
+	if res.StatusCode == http.StatusPartialContent {
+		// Content-Range: bytes 0-1023/146515
+		contentRange := res.Header.Get("Content-Range")
+		total := contentRange[strings.LastIndex(contentRange, "/")+1:]
+		if size, err := strconv.ParseInt(total, 10, 64); err == nil {
+			info.Size = size
+		}
+	} else if res.ContentLength >= 0 {
+		info.Size = res.ContentLength
+	}
+	return info
+}
+
+// headURL asks for the headers of the resource only. Not every server handles
+// HEAD requests properly so failures are only reported through ok.
+func headURL(ctx context.Context, URL string) (info resourceInfo, ok bool) {
+	req, err := http.NewRequestWithContext(ctx, "HEAD", URL, nil)
+	if err != nil {
+		return
+	}
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return
+	}
+	return infoFromResponse(res), true
+}
+
+// getURL requests only the first limit bytes of the resource. Servers which
+// ignore the Range header are cut off by the caller reading at most limit
+// bytes. Caller is responsible for closing the response body.
+func getURL(ctx context.Context, URL string, limit int64) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=0-%d", limit-1))
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode >= 400 {
+		res.Body.Close()
+		return nil, fmt.Errorf("request for %s returned code: %d",
+			URL, res.StatusCode)
+	}
+	return res, nil
+}
+
+// humanSize formats number of bytes for humans, e.g. 2.3 MB
+func humanSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// describeFile returns a short description of a non-HTML resource such as
+// "PDF, 2.3 MB" or nothing when describing files is disabled
+func describeFile(info resourceInfo) string {
+	if !describeFiles || info.MediaType == "" {
+		return ""
+	}
+	name, found := mediaTypeNames[info.MediaType]
+	if !found {
+		name = info.MediaType
+	}
+	if info.Size > 0 {
+		return fmt.Sprintf("%s, %s", name, humanSize(info.Size))
+	}
+	return name
+}
+
+// describeImage returns media type and dimensions of image, e.g.
+// "image/png 1920×1080"
+func describeImage(info resourceInfo, body io.Reader) string {
+	config, _, err := image.DecodeConfig(body)
+	if err != nil {
+		return describeFile(info)
+	}
+	return fmt.Sprintf("%s %d×%d", info.MediaType, config.Width, config.Height)
+}
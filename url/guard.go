@@ -0,0 +1,122 @@
+package url
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+const (
+	allowPrivateEnv = "URL_ALLOW_PRIVATE_ADDRESSES"
+	maxRedirects    = 10
+)
+
+var (
+	errForbiddenAddress = errors.New("refusing to connect to non-public address")
+	errDomainNotAllowed = errors.New("domain is not allowed in this channel")
+
+	// allowPrivateAddresses disables the dialer guard, e.g. for bots which
+	// should describe intranet pages
+	allowPrivateAddresses bool
+
+	// nonPublicNetworks complements the checks provided by net.IP methods
+	nonPublicNetworks = parseCIDRs(
+		"0.0.0.0/8",     // "this" network
+		"100.64.0.0/10", // carrier-grade NAT
+		"192.0.0.0/24",  // IETF protocol assignments
+		"198.18.0.0/15", // benchmarking
+		"240.0.0.0/4",   // reserved
+	)
+)
+
+type policyKey struct{}
+
+func parseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets
+}
+
+// isPublicIP reports whether ip is a globally routable unicast address
+func isPublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsMulticast() {
+		return false
+	}
+	for _, ipNet := range nonPublicNetworks {
+		if ipNet.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// guardControl is called by the dialer after DNS resolution, right before
+// connecting. Checking the address here instead of the URL covers DNS names
+// pointing to internal addresses and redirects alike.
+func guardControl(network, address string, _ syscall.RawConn) error {
+	if allowPrivateAddresses {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !isPublicIP(ip) {
+		return fmt.Errorf("%w: %s", errForbiddenAddress, host)
+	}
+	return nil
+}
+
+// withPolicy attaches channel configuration to requests made with ctx so
+// that it can be enforced on redirects
+func withPolicy(ctx context.Context, policy *channelConfig) context.Context {
+	return context.WithValue(ctx, policyKey{}, policy)
+}
+
+func policyFromContext(ctx context.Context) *channelConfig {
+	policy, _ := ctx.Value(policyKey{}).(*channelConfig)
+	return policy
+}
+
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= maxRedirects {
+		return fmt.Errorf("stopped after %d redirects", maxRedirects)
+	}
+	if policy := policyFromContext(req.Context()); !policy.allowed(req.URL) {
+		return fmt.Errorf("%w: %s", errDomainNotAllowed, req.URL.Hostname())
+	}
+	return nil
+}
+
+// newGuardedClient returns HTTP client which refuses to connect to
+// non-public addresses and enforces channel policy on redirects. Proxies are
+// deliberately not used as the guard would only see address of the proxy.
+func newGuardedClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: fetchTimeout,
+		Control: guardControl,
+	}
+	return &http.Client{
+		Timeout:       fetchTimeout,
+		CheckRedirect: checkRedirect,
+		Transport: &http.Transport{
+			Proxy:               nil,
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: fetchTimeout,
+			MaxIdleConns:        10,
+			IdleConnTimeout:     90 * time.Second,
+		},
+	}
+}
@@ -0,0 +1,88 @@
+package url
+
+import (
+	"net/url"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	maxTitleLengthEnv     = "URL_MAX_TITLE_LENGTH"
+	defaultMaxTitleLength = 200
+	// minTitleScore is the minimal share of title words not found in URL
+	minTitleScore = 0.2
+	ellipsis      = "…"
+)
+
+var (
+	maxTitleLength = defaultMaxTitleLength
+
+	// noiseWords carry no information on their own and are ignored when
+	// scoring titles
+	noiseWords = map[string]bool{
+		"a": true, "an": true, "and": true, "the": true, "of": true,
+		"on": true, "in": true, "at": true, "to": true, "for": true,
+		"www": true, "com": true, "org": true, "net": true, "html": true,
+		"htm": true, "php": true, "index": true, "home": true, "page": true,
+		"homepage": true, "welcome": true,
+	}
+)
+
+// tokenize splits text to lower case words
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// urlTokens returns set of words found in host and path of the URLs
+func urlTokens(urls ...*url.URL) map[string]bool {
+	tokens := make(map[string]bool)
+	for _, URL := range urls {
+		for _, token := range tokenize(URL.Hostname() + " " + URL.Path) {
+			tokens[token] = true
+		}
+	}
+	return tokens
+}
+
+// titleScore returns the share of meaningful title words which are not
+// already present in the URLs. Zero means the title adds no information.
+func titleScore(title string, urls ...*url.URL) float64 {
+	known := urlTokens(urls...)
+	words, novel := 0, 0
+	for _, token := range tokenize(title) {
+		if noiseWords[token] || utf8.RuneCountInString(token) < 2 {
+			continue
+		}
+		words++
+		if !known[token] {
+			novel++
+		}
+	}
+	if words == 0 {
+		return 0
+	}
+	return float64(novel) / float64(words)
+}
+
+// titleAddsInformation reports whether title is worth posting for the URLs,
+// i.e. it is not just the site name or the URL slug
+func titleAddsInformation(title string, urls ...*url.URL) bool {
+	return titleScore(title, urls...) >= minTitleScore
+}
+
+// truncateTitle shortens text to at most max characters, preferably at word
+// boundary, and marks the cut with an ellipsis
+func truncateTitle(text string, max int) string {
+	if max <= 0 || utf8.RuneCountInString(text) <= max {
+		return text
+	}
+	runes := []rune(text)
+	cut := string(runes[:max-1])
+	if space := strings.LastIndex(cut, " "); space > len(cut)/2 {
+		cut = cut[:space]
+	}
+	return strings.TrimRight(cut, " .,:;-|") + ellipsis
+}
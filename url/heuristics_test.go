@@ -0,0 +1,62 @@
+package url
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestTitleHeuristics(t *testing.T) {
+	Convey("Given a title of a page", t, func() {
+		cases := []struct {
+			title    string
+			url      string
+			expected bool
+		}{
+			{"GitHub", "https://github.com", false},
+			{"Home - GitHub", "https://www.github.com/", false},
+			{"my-great-post", "https://blog.example.com/posts/my-great-post", false},
+			{"My Great Post | Blog", "https://example.com/blog/my-great-post.html", false},
+			{"Example Domain", "http://example.com", true},
+			{"golang/go: The Go programming language", "https://github.com/golang/go", true},
+			{"cmd/go: build fails · Issue #123 · golang/go · GitHub", "https://github.com/golang/go/issues/123", true},
+			{"", "https://example.com", false},
+			{"...", "https://example.com", false},
+			{"Ünïcödé Straße", "https://example.de/strasse", true},
+		}
+		for _, c := range cases {
+			parsedURL, _ := url.Parse(c.url)
+			So(titleAddsInformation(c.title, parsedURL), ShouldEqual, c.expected)
+		}
+	})
+
+	Convey("Given a title of a page behind a redirect", t, func() {
+		short, _ := url.Parse("https://bit.ly/3xYz")
+		final, _ := url.Parse("https://example.com/my-great-post")
+
+		So(titleAddsInformation("My great post", short), ShouldBeTrue)
+		So(titleAddsInformation("My great post", short, final), ShouldBeFalse)
+	})
+
+	Convey("Given a long title", t, func() {
+		cases := []struct {
+			title    string
+			max      int
+			expected string
+		}{
+			{"Short title", 20, "Short title"},
+			{"Exactly twenty chars", 20, "Exactly twenty chars"},
+			{"A title which is way too long", 20, "A title which is…"},
+			{"Averyveryverylongwordwithoutspaces", 10, "Averyvery…"},
+			{"Title: with punctuation", 8, "Title…"},
+			{"Žluťoučký kůň úpěl ďábelské ódy", 12, "Žluťoučký…"},
+			{"Unlimited", 0, "Unlimited"},
+		}
+		for _, c := range cases {
+			So(truncateTitle(c.title, c.max), ShouldEqual, c.expected)
+		}
+		So(len([]rune(truncateTitle(strings.Repeat("word ", 100), 50))),
+			ShouldBeLessThanOrEqualTo, 50)
+	})
+}
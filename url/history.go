@@ -0,0 +1,148 @@
+package url
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-chat-bot/bot"
+)
+
+const (
+	historySize       = 500 // links remembered per channel
+	defaultLinksCount = 5
+	maxLinksCount     = 20
+	linksUsage        = "Expecting at most 1 argument: <number of links>"
+)
+
+// linkRecord is a single post of a link in a channel
+type linkRecord struct {
+	URL         string
+	Key         string // normalized URL
+	Nick        string
+	Posted      time.Time
+	Description string
+}
+
+// linkHistory remembers recent links posted to each channel
+type linkHistory struct {
+	sync.Mutex
+	channels map[string][]linkRecord // channel -> links, oldest first
+}
+
+var (
+	history = &linkHistory{channels: make(map[string][]linkRecord)}
+)
+
+// firstPost returns the oldest remembered post of link with given key
+func (h *linkHistory) firstPost(channel, key string) (linkRecord, bool) {
+	h.Lock()
+	defer h.Unlock()
+	for _, link := range h.channels[channel] {
+		if link.Key == key {
+			return link, true
+		}
+	}
+	return linkRecord{}, false
+}
+
+func (h *linkHistory) record(channel string, link linkRecord) {
+	h.Lock()
+	defer h.Unlock()
+	links := append(h.channels[channel], link)
+	if len(links) > historySize {
+		links = links[len(links)-historySize:]
+	}
+	h.channels[channel] = links
+}
+
+// recent returns up to n most recent links in channel, newest first
+func (h *linkHistory) recent(channel string, n int) []linkRecord {
+	h.Lock()
+	defer h.Unlock()
+	links := h.channels[channel]
+	ret := make([]linkRecord, 0, n)
+	for i := len(links) - 1; i >= 0 && len(ret) < n; i-- {
+		ret = append(ret, links[i])
+	}
+	return ret
+}
+
+// formatAge returns how long ago something happened, e.g. "2h ago"
+func formatAge(age time.Duration) string {
+	switch {
+	case age < time.Minute:
+		return "just now"
+	case age < time.Hour:
+		return fmt.Sprintf("%dm ago", int(age/time.Minute))
+	case age < 24*time.Hour:
+		return fmt.Sprintf("%dh ago", int(age/time.Hour))
+	default:
+		return fmt.Sprintf("%dd ago", int(age/(24*time.Hour)))
+	}
+}
+
+// repostNotice returns note about earlier post of the same link unless it was
+// posted by the same person
+func repostNotice(first linkRecord, nick string) string {
+	if first.Nick == nick {
+		return ""
+	}
+	return fmt.Sprintf("(already posted by %s %s)", first.Nick,
+		formatAge(time.Since(first.Posted)))
+}
+
+func formatLink(link linkRecord) string {
+	if link.Description == "" {
+		return fmt.Sprintf("%s %s: %s", link.Nick,
+			formatAge(time.Since(link.Posted)), link.URL)
+	}
+	return fmt.Sprintf("%s %s: %s - %s", link.Nick,
+		formatAge(time.Since(link.Posted)), link.URL, link.Description)
+}
+
+// recentLinks returns lines listing recent links as requested by command
+// arguments
+func recentLinks(cmd *bot.Cmd) []string {
+	count := defaultLinksCount
+	if len(cmd.Args) > 1 {
+		return []string{linksUsage}
+	}
+	if len(cmd.Args) == 1 {
+		n, err := strconv.Atoi(cmd.Args[0])
+		if err != nil || n <= 0 {
+			return []string{linksUsage}
+		}
+		count = n
+		if count > maxLinksCount {
+			count = maxLinksCount
+		}
+	}
+
+	recent := history.recent(cmd.Channel, count)
+	if len(recent) == 0 {
+		return []string{"No links were posted in this channel yet"}
+	}
+	lines := make([]string, 0, len(recent))
+	for _, link := range recent {
+		lines = append(lines, formatLink(link))
+	}
+	return lines
+}
+
+func links(cmd *bot.Cmd) (bot.CmdResultV3, error) {
+	result := bot.CmdResultV3{
+		Channel: cmd.Channel,
+		Message: make(chan string),
+		Done:    make(chan bool, 1)}
+
+	lines := recentLinks(cmd)
+	go func() {
+		for _, line := range lines {
+			result.Message <- line
+		}
+		result.Done <- true
+	}()
+	return result, nil
+}
@@ -0,0 +1,115 @@
+package url
+
+import (
+	"io"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"golang.org/x/net/html/charset"
+)
+
+// pageMetadata holds the information extracted from the head of a HTML page
+type pageMetadata struct {
+	Title       string
+	Description string
+	SiteName    string
+}
+
+// metaTitleKeys and metaDescriptionKeys are listed in order of preference
+var (
+	metaTitleKeys       = []string{"og:title", "twitter:title"}
+	metaDescriptionKeys = []string{"og:description", "twitter:description", "description"}
+	metaSiteNameKeys    = []string{"og:site_name"}
+)
+
+// cleanText collapses all runs of whitespace (including new lines) into
+// single spaces
+func cleanText(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
+
+func firstMeta(meta map[string]string, keys []string) string {
+	for _, key := range keys {
+		if value := meta[key]; value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// parseMetadata reads HTML from body until the end of the document head and
+// returns the title, description and site name found there. contentType is
+// the value of Content-Type header and is used together with BOM and <meta>
+// declarations to convert the page to UTF-8.
+func parseMetadata(body io.Reader, contentType string) (pageMetadata, error) {
+	reader, err := charset.NewReader(body, contentType)
+	if err != nil {
+		return pageMetadata{}, err
+	}
+
+	meta := make(map[string]string)
+	title := ""
+	z := html.NewTokenizer(reader)
+	for done := false; !done; {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			if z.Err() != io.EOF {
+				return pageMetadata{}, z.Err()
+			}
+			done = true
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			switch atom.Lookup(name) {
+			case atom.Title:
+				if tt == html.StartTagToken && title == "" && z.Next() == html.TextToken {
+					title = cleanText(string(z.Text()))
+				}
+			case atom.Meta:
+				if hasAttr {
+					key, value := metaAttributes(z)
+					if _, found := meta[key]; !found && key != "" {
+						meta[key] = cleanText(value)
+					}
+				}
+			case atom.Body:
+				done = true
+			}
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			if atom.Lookup(name) == atom.Head {
+				done = true
+			}
+		}
+	}
+
+	m := pageMetadata{
+		Title:       firstMeta(meta, metaTitleKeys),
+		Description: firstMeta(meta, metaDescriptionKeys),
+		SiteName:    firstMeta(meta, metaSiteNameKeys),
+	}
+	if m.Title == "" {
+		m.Title = title
+	}
+	return m, nil
+}
+
+// metaAttributes returns the name (or OpenGraph property) and content of the
+// <meta> tag the tokenizer is positioned at
+func metaAttributes(z *html.Tokenizer) (key, value string) {
+	for {
+		attr, val, more := z.TagAttr()
+		switch string(attr) {
+		case "name", "property":
+			if key == "" {
+				key = strings.ToLower(strings.TrimSpace(string(val)))
+			}
+		case "content":
+			value = string(val)
+		}
+		if !more {
+			return
+		}
+	}
+}
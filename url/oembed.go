@@ -0,0 +1,96 @@
+package url
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/go-chat-bot/plugins/web"
+)
+
+// oEmbedProvider describes a site which can describe its pages through
+// oEmbed (https://oembed.com) endpoint
+type oEmbedProvider struct {
+	Hosts    []string // host names (and their subdomains) served by provider
+	Endpoint string   // oEmbed endpoint, page URL is added as url parameter
+}
+
+type oEmbedReply struct {
+	Type         string `json:"type"`
+	Title        string `json:"title"`
+	AuthorName   string `json:"author_name"`
+	ProviderName string `json:"provider_name"`
+}
+
+var (
+	oEmbedProviders = []oEmbedProvider{
+		{
+			Hosts:    []string{"youtube.com", "youtu.be"},
+			Endpoint: "https://www.youtube.com/oembed?format=json",
+		},
+		{
+			Hosts:    []string{"vimeo.com"},
+			Endpoint: "https://vimeo.com/api/oembed.json",
+		},
+		{
+			Hosts:    []string{"soundcloud.com"},
+			Endpoint: "https://soundcloud.com/oembed?format=json",
+		},
+		{
+			Hosts:    []string{"open.spotify.com"},
+			Endpoint: "https://open.spotify.com/oembed",
+		},
+		{
+			Hosts:    []string{"flickr.com", "flic.kr"},
+			Endpoint: "https://www.flickr.com/services/oembed/?format=json",
+		},
+	}
+)
+
+func hostMatches(host, domain string) bool {
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+// findOEmbedProvider returns provider responsible for given URL or nil if
+// there is none
+func findOEmbedProvider(pageURL *url.URL) *oEmbedProvider {
+	host := strings.ToLower(pageURL.Hostname())
+	for i, provider := range oEmbedProviders {
+		for _, domain := range provider.Hosts {
+			if hostMatches(host, domain) {
+				return &oEmbedProviders[i]
+			}
+		}
+	}
+	return nil
+}
+
+// oEmbedSummary asks the provider about the page and formats the reply to a
+// one-line summary such as "Title by Author (Provider)"
+func oEmbedSummary(provider *oEmbedProvider, pageURL string) (string, error) {
+	endpoint, err := url.Parse(provider.Endpoint)
+	if err != nil {
+		return "", err
+	}
+	q := endpoint.Query()
+	q.Set("url", pageURL)
+	endpoint.RawQuery = q.Encode()
+
+	reply := &oEmbedReply{}
+	err = web.GetJSON(endpoint.String(), reply)
+	if err != nil {
+		return "", err
+	}
+
+	title := cleanText(reply.Title)
+	if title == "" {
+		return "", nil
+	}
+	if author := cleanText(reply.AuthorName); author != "" {
+		title = fmt.Sprintf("%s by %s", title, author)
+	}
+	if provider := cleanText(reply.ProviderName); provider != "" {
+		title = fmt.Sprintf("%s (%s)", title, provider)
+	}
+	return title, nil
+}
@@ -1,72 +1,246 @@
 package url
 
 import (
+	"context"
+	"fmt"
 	"github.com/go-chat-bot/bot"
-	"github.com/go-chat-bot/plugins/web"
-	"html"
+	"github.com/go-chat-bot/plugins/origin"
+	"io"
+	"log"
+	"mvdan.cc/xurls/v2"
 	"net/url"
-	"regexp"
+	"os"
+	"strconv"
 	"strings"
+	"time"
 )
 
 const (
-	minDomainLength = 3
+	maxURLs               = 5 // at most this many URLs are described per message
+	includeDescriptionEnv = "URL_INCLUDE_DESCRIPTION"
+	includeSiteNameEnv    = "URL_INCLUDE_SITE_NAME"
 )
 
 var (
-	re = regexp.MustCompile("<title>\\n*?(.*?)\\n*?<\\/title>")
+	urlRegex           = xurls.Relaxed()
+	includeDescription bool
+	includeSiteName    bool
 )
 
-func canBeURLWithoutProtocol(text string) bool {
-	return len(text) > minDomainLength &&
-		!strings.HasPrefix(text, "http") &&
-		strings.Contains(text, ".")
-}
-
-func extractURL(text string) string {
-	extractedURL := ""
-	for _, value := range strings.Split(text, " ") {
-		if canBeURLWithoutProtocol(value) {
-			value = "http://" + value
+// extractURLs returns all distinct http(s) URLs found in text. URLs without
+// scheme (e.g. google.com) are assumed to be http.
+func extractURLs(text string) []string {
+	var urls []string
+	seen := make(map[string]bool)
+	for _, match := range urlRegex.FindAllString(text, -1) {
+		if !strings.Contains(match, "://") {
+			if strings.Contains(match, "@") {
+				// e-mail address, not a web page
+				continue
+			}
+			match = "http://" + match
 		}
 
-		parsedURL, err := url.Parse(value)
-		if err != nil {
+		parsedURL, err := url.Parse(match)
+		if err != nil || parsedURL.Host == "" {
 			continue
 		}
-		if strings.HasPrefix(parsedURL.Scheme, "http") {
-			extractedURL = parsedURL.String()
+		if parsedURL.Scheme != "http" && parsedURL.Scheme != "https" {
+			continue
+		}
+		extractedURL := parsedURL.String()
+		if seen[extractedURL] {
+			continue
+		}
+		seen[extractedURL] = true
+		urls = append(urls, extractedURL)
+		if len(urls) == maxURLs {
 			break
 		}
 	}
-	return extractedURL
+	return urls
 }
 
-func urlTitle(cmd *bot.PassiveCmd) (string, error) {
-	URL := extractURL(cmd.Raw)
+// formatMetadata turns page metadata into a single line reply. Site name and
+// description are only added when enabled and when they add something new.
+func formatMetadata(meta pageMetadata) string {
+	if meta.Title == "" {
+		return ""
+	}
 
-	if URL == "" {
-		return "", nil
+	title := meta.Title
+	if includeSiteName && meta.SiteName != "" &&
+		!strings.Contains(title, meta.SiteName) {
+		title = fmt.Sprintf("%s | %s", title, meta.SiteName)
+	}
+	if includeDescription && meta.Description != "" &&
+		meta.Description != meta.Title {
+		title = fmt.Sprintf("%s - %s", title, meta.Description)
 	}
+	return title
+}
 
-	body, err := web.GetBody(URL)
+// describeURL returns one line description of the URL. HTML pages are
+// described by their title, other content by its type and size. Channel
+// policy attached to ctx is enforced on redirects.
+func describeURL(ctx context.Context, URL string) (string, error) {
+	parsedURL, err := url.Parse(URL)
 	if err != nil {
 		return "", err
 	}
+	if provider := findOEmbedProvider(parsedURL); provider != nil {
+		summary, err := oEmbedSummary(provider, URL)
+		if err != nil {
+			log.Printf("oEmbed lookup for %s failed, falling back to HTML: %v",
+				URL, err)
+		} else if summary != "" {
+			return summary, nil
+		}
+	}
 
-	title := re.FindString(string(body))
-	if title == "" {
-		return "", nil
+	headInfo, ok := headURL(ctx, URL)
+	if ok && !headInfo.isHTML() && !(describeFiles && headInfo.isImage()) {
+		// no need to download anything
+		return describeFile(headInfo), nil
 	}
 
-	title = strings.Replace(title, "\n", "", -1)
-	title = title[strings.Index(title, ">")+1 : strings.LastIndex(title, "<")]
+	limit := maxBytes
+	if headInfo.isImage() {
+		limit = maxImageBytes
+	}
+	res, err := getURL(ctx, URL, limit)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
 
-	return html.UnescapeString(title), nil
+	info := infoFromResponse(res)
+	if info.Size < 0 {
+		info.Size = headInfo.Size
+	}
+	body := io.LimitReader(res.Body, limit)
+	switch {
+	case info.isHTML():
+		meta, err := parseMetadata(body, info.ContentType)
+		if err != nil {
+			return "", err
+		}
+		if meta.Title != "" &&
+			!titleAddsInformation(meta.Title, parsedURL, res.Request.URL) {
+			log.Printf("Title %q of %s adds no information, skipping",
+				meta.Title, URL)
+			return "", nil
+		}
+		return formatMetadata(meta), nil
+	case info.isImage() && describeFiles:
+		return describeImage(info, body), nil
+	default:
+		return describeFile(info), nil
+	}
+}
+
+func urlTitle(cmd *bot.PassiveCmd) (bot.CmdResultV3, error) {
+	result := bot.CmdResultV3{
+		Channel: cmd.Channel,
+		Message: make(chan string),
+		Done:    make(chan bool, 1)}
+
+	nick := ""
+	if cmd.User != nil {
+		nick = cmd.User.Nick
+	}
+	policy := getChannelConfig(cmd.Channel)
+	ctx := withPolicy(context.Background(), policy)
+	urls := extractURLs(cmd.Raw)
+	go func() {
+		for _, URL := range urls {
+			parsedURL, err := url.Parse(URL)
+			if err != nil || !policy.allowed(parsedURL) {
+				continue
+			}
+			key := normalizeURL(parsedURL)
+			// the policy also applies to redirects, a description fetched
+			// under another policy may come from a host this one denies
+			cacheKey := policy.name() + "\x00" + key
+			description, found := descriptions.get(cacheKey)
+			if !found {
+				description, err = describeURL(ctx, URL)
+				if err != nil {
+					log.Printf("Failed describing %s: %v", URL, err)
+				} else {
+					descriptions.put(cacheKey, description)
+				}
+			}
+
+			description = truncateTitle(description, maxTitleLength)
+			first, posted := history.firstPost(cmd.Channel, key)
+			history.record(cmd.Channel, linkRecord{
+				URL:         URL,
+				Key:         key,
+				Nick:        nick,
+				Posted:      time.Now(),
+				Description: description,
+			})
+			if posted {
+				description = strings.TrimSpace(description + " " +
+					repostNotice(first, nick))
+			}
+
+			if description != "" {
+				result.Message <- description
+			}
+		}
+		result.Done <- true
+	}()
+
+	return result, nil
 }
 
 func init() {
-	bot.RegisterPassiveCommand(
+	_, includeDescription = os.LookupEnv(includeDescriptionEnv)
+	_, includeSiteName = os.LookupEnv(includeSiteNameEnv)
+	_, describeFiles = os.LookupEnv(describeFilesEnv)
+	_, allowPrivateAddresses = os.LookupEnv(allowPrivateEnv)
+	for _, domain := range strings.Split(os.Getenv(internalDomainsEnv), ",") {
+		if domain = strings.TrimSpace(domain); domain != "" {
+			internalDomains = append(internalDomains, domain)
+		}
+	}
+	if limit := os.Getenv(maxBytesEnv); limit != "" {
+		value, err := strconv.ParseInt(limit, 10, 64)
+		if err != nil || value <= 0 {
+			log.Printf("Invalid %s value %q, using default", maxBytesEnv, limit)
+		} else {
+			maxBytes = value
+		}
+	}
+
+	if length, err := strconv.Atoi(os.Getenv(maxTitleLengthEnv)); err == nil {
+		maxTitleLength = length
+	}
+	cacheSize, cacheTTL := defaultCacheSize, defaultCacheTTL
+	if size, err := strconv.Atoi(os.Getenv(cacheSizeEnv)); err == nil {
+		cacheSize = size
+	}
+	if ttl, err := strconv.Atoi(os.Getenv(cacheTTLEnv)); err == nil {
+		cacheTTL = ttl
+	}
+	descriptions = newDescriptionCache(cacheSize,
+		time.Duration(cacheTTL)*time.Minute)
+
+	if confFile := os.Getenv(channelConfigEnv); confFile != "" {
+		err := loadChannelConfigs(confFile)
+		if err != nil {
+			log.Printf("Error loading channel configuration (non-fatal): %v\n", err)
+		}
+	}
+
+	bot.RegisterPassiveCommandV2(
 		"url",
-		urlTitle)
+		origin.PassiveV2("url", urlTitle))
+	bot.RegisterCommandV3(
+		"links",
+		"Lists links recently posted in this channel",
+		"5",
+		origin.CommandV3("url", links))
 }
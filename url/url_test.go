@@ -1,22 +1,78 @@
 package url
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"github.com/go-chat-bot/bot"
 	. "github.com/smartystreets/goconvey/convey"
+	"image"
+	"image/png"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	neturl "net/url"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 )
 
+// resetState forgets cached descriptions and link history
+func resetState() {
+	descriptions = newDescriptionCache(defaultCacheSize,
+		defaultCacheTTL*time.Minute)
+	history = &linkHistory{channels: make(map[string][]linkRecord)}
+}
+
+// titles runs the passive command and collects all of its replies
+func titles(cmd *bot.PassiveCmd) ([]string, error) {
+	result, err := urlTitle(cmd)
+	if err != nil {
+		return nil, err
+	}
+	var messages []string
+	for {
+		select {
+		case message := <-result.Message:
+			messages = append(messages, message)
+		case <-result.Done:
+			return messages, nil
+		}
+	}
+}
+
+// firstTitle runs the passive command and returns its first reply
+func firstTitle(cmd *bot.PassiveCmd) (string, error) {
+	messages, err := titles(cmd)
+	if len(messages) == 0 {
+		return "", err
+	}
+	return messages[0], err
+}
+
 func TestURL(t *testing.T) {
+	// test servers listen on loopback
+	allowPrivateAddresses = true
+	defer func() { allowPrivateAddresses = false }()
+
 	cmd := &bot.PassiveCmd{}
 	getExecuted := false
 	getResult := ""
+	getContentType := ""
+	getMethods := []string{}
+	getRange := ""
 
 	ts := httptest.NewServer(
 		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			getExecuted = true
+			getMethods = append(getMethods, r.Method)
+			getRange = r.Header.Get("Range")
+			if getContentType != "" {
+				w.Header().Set("Content-Type", getContentType)
+			}
+			w.Header().Set("Content-Length", strconv.Itoa(len(getResult)+1))
 			fmt.Fprintln(w, getResult)
 		}))
 
@@ -27,11 +83,15 @@ func TestURL(t *testing.T) {
 		Reset(func() {
 			getExecuted = false
 			getResult = ""
+			getContentType = ""
+			getMethods = []string{}
+			getRange = ""
+			resetState()
 		})
 
 		Convey("If the text is not a URL", func() {
 			cmd.Raw = "foo bar"
-			title, err := urlTitle(cmd)
+			title, err := firstTitle(cmd)
 
 			So(getExecuted, ShouldBeFalse)
 			So(err, ShouldBeNil)
@@ -41,7 +101,7 @@ func TestURL(t *testing.T) {
 		Convey("If the url contains no title", func() {
 			cmd.Raw = "foo " + url
 
-			title, err := urlTitle(cmd)
+			title, err := firstTitle(cmd)
 
 			So(getExecuted, ShouldBeTrue)
 			So(err, ShouldBeNil)
@@ -52,7 +112,7 @@ func TestURL(t *testing.T) {
 			getResult = "<title>Google</title>"
 			cmd.Raw = fmt.Sprintf("foo %s bar", url)
 
-			title, err := urlTitle(cmd)
+			title, err := firstTitle(cmd)
 
 			So(getExecuted, ShouldBeTrue)
 			So(err, ShouldBeNil)
@@ -62,44 +122,540 @@ func TestURL(t *testing.T) {
 		Convey("If the text is a https URL", func() {
 			httpsURL := "https://google.com"
 
-			extractedURL := extractURL(fmt.Sprintf("foo %s bar", httpsURL))
+			extractedURLs := extractURLs(fmt.Sprintf("foo %s bar", httpsURL))
 
-			So(extractedURL, ShouldEqual, httpsURL)
+			So(extractedURLs, ShouldResemble, []string{httpsURL})
 		})
 
 		Convey("If title starts or ends with a new line", func() {
 			getResult = "<title>\nGoogle\n</title>"
 			cmd.Raw = url
 
-			title, err := urlTitle(cmd)
+			title, err := firstTitle(cmd)
 
 			So(err, ShouldBeNil)
 			So(title, ShouldEqual, "Google")
 		})
 
-		Convey("If an error occurs while fetching the url", func() {
-			cmd.Raw = "127.0.0.1:0"
+		Convey("If the title tag has attributes and spans multiple lines", func() {
+			getResult = "<html><head><title lang=\"en\">\n  Go &amp;\n  Chat\n</title></head></html>"
+			cmd.Raw = url
+
+			title, err := firstTitle(cmd)
+
+			So(err, ShouldBeNil)
+			So(title, ShouldEqual, "Go & Chat")
+		})
+
+		Convey("If the page has an OpenGraph title", func() {
+			getResult = `<head><title>Google</title>
+				<meta property="og:title" content="Google Search">
+				<meta name="twitter:title" content="Google on Twitter"></head>`
+			cmd.Raw = url
+
+			title, err := firstTitle(cmd)
+
+			So(err, ShouldBeNil)
+			So(title, ShouldEqual, "Google Search")
+		})
+
+		Convey("If the page only has a Twitter title", func() {
+			getResult = `<div id="app"></div><meta name="twitter:title" content="SPA">`
+			cmd.Raw = url
+
+			title, err := firstTitle(cmd)
+
+			So(err, ShouldBeNil)
+			So(title, ShouldEqual, "SPA")
+		})
+
+		Convey("If the title is outside of the document head", func() {
+			getResult = "<head></head><body><svg><title>Icon</title></svg></body>"
+			cmd.Raw = url
+
+			title, err := firstTitle(cmd)
+
+			So(err, ShouldBeNil)
+			So(title, ShouldBeBlank)
+		})
+
+		Convey("If the page declares a non UTF-8 charset", func() {
+			getContentType = "text/html"
+			getResult = "<meta charset=\"iso-8859-1\"><title>Caf\xe9</title>"
+			cmd.Raw = url
+
+			title, err := firstTitle(cmd)
+
+			So(err, ShouldBeNil)
+			So(title, ShouldEqual, "Café")
+		})
+
+		Convey("If description and site name are enabled", func() {
+			includeDescription, includeSiteName = true, true
+			Reset(func() {
+				includeDescription, includeSiteName = false, false
+			})
+			getResult = `<title>Go</title>
+				<meta property="og:site_name" content="GitHub">
+				<meta property="og:description" content="The Go programming language">`
+			cmd.Raw = url
+
+			title, err := firstTitle(cmd)
+
+			So(err, ShouldBeNil)
+			So(title, ShouldEqual, "Go | GitHub - The Go programming language")
+		})
+
+		Convey("If the url belongs to an oEmbed provider", func() {
+			oEmbedQuery := ""
+			oEmbedResult := `{"title": "Gopher", "author_name": "Go team", "provider_name": "Tube"}`
+			oEmbedServer := httptest.NewServer(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					oEmbedQuery = r.URL.Query().Get("url")
+					fmt.Fprintln(w, oEmbedResult)
+				}))
+			savedProviders := oEmbedProviders
+			oEmbedProviders = []oEmbedProvider{
+				{Hosts: []string{"127.0.0.1"}, Endpoint: oEmbedServer.URL + "/oembed"},
+			}
+			Reset(func() {
+				oEmbedProviders = savedProviders
+				oEmbedServer.Close()
+			})
+			cmd.Raw = url + "/watch"
+
+			Convey("The summary is made from the oEmbed reply", func() {
+				title, err := firstTitle(cmd)
+
+				So(err, ShouldBeNil)
+				So(title, ShouldEqual, "Gopher by Go team (Tube)")
+				So(oEmbedQuery, ShouldEqual, url+"/watch")
+				So(getExecuted, ShouldBeFalse)
+			})
+
+			Convey("The page is used when the oEmbed reply is broken", func() {
+				oEmbedResult = "not json"
+				getResult = "<title>Gopher</title>"
+
+				title, err := firstTitle(cmd)
+
+				So(err, ShouldBeNil)
+				So(title, ShouldEqual, "Gopher")
+				So(getExecuted, ShouldBeTrue)
+			})
+		})
 
-			_, err := urlTitle(cmd)
+		Convey("If an error occurs while fetching the url", func() {
+			_, err := describeURL(context.Background(), "http://127.0.0.1:0")
 
 			So(err, ShouldNotBeNil)
 		})
 
+		Convey("If the text contains several urls", func() {
+			pathServer := httptest.NewServer(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					fmt.Fprintf(w, "<title>Article about %s</title>", r.URL.Path)
+				}))
+			Reset(pathServer.Close)
+			cmd.Raw = fmt.Sprintf("see %[1]s/first, %[1]s/broken:0 and (%[1]s/second)",
+				pathServer.URL)
+
+			messages, err := titles(cmd)
+
+			So(err, ShouldBeNil)
+			So(messages, ShouldResemble, []string{
+				"Article about /first", "Article about /broken:0", "Article about /second"})
+		})
+
+		Convey("If the same url is repeated and mixed with e-mail addresses", func() {
+			extractedURLs := extractURLs("mail me@example.com about google.com or http://google.com")
+
+			So(extractedURLs, ShouldResemble, []string{"http://google.com"})
+		})
+
+		Convey("If the text contains too many urls", func() {
+			text := strings.Repeat("a.com b.com c.com d.com e.com f.com ", 2)
+
+			So(extractURLs(text), ShouldHaveLength, maxURLs)
+		})
+
+		Convey("If the page is bigger than the limit", func() {
+			maxBytes = 64
+			Reset(func() {
+				maxBytes = defaultMaxBytes
+			})
+			getResult = strings.Repeat("<!-- padding -->", 10) + "<title>Late</title>"
+			cmd.Raw = url
+
+			title, err := firstTitle(cmd)
+
+			So(err, ShouldBeNil)
+			So(title, ShouldBeBlank)
+			So(getRange, ShouldEqual, "bytes=0-63")
+		})
+
+		Convey("If the url is not a HTML page", func() {
+			getContentType = "application/pdf"
+			getResult = strings.Repeat("x", 2411724)
+			cmd.Raw = url
+
+			Convey("It is skipped by default", func() {
+				title, err := firstTitle(cmd)
+
+				So(err, ShouldBeNil)
+				So(title, ShouldBeBlank)
+				So(getMethods, ShouldResemble, []string{"HEAD"})
+			})
+
+			Convey("It is described when enabled", func() {
+				describeFiles = true
+				Reset(func() {
+					describeFiles = false
+				})
+
+				title, err := firstTitle(cmd)
+
+				So(err, ShouldBeNil)
+				So(title, ShouldEqual, "PDF, 2.3 MB")
+				So(getMethods, ShouldResemble, []string{"HEAD"})
+			})
+		})
+
+		Convey("If the url is an image and files are described", func() {
+			describeFiles = true
+			Reset(func() {
+				describeFiles = false
+			})
+			buf := &bytes.Buffer{}
+			png.Encode(buf, image.NewGray(image.Rect(0, 0, 192, 108)))
+			getContentType = "image/png"
+			getResult = buf.String()
+			cmd.Raw = url
+
+			title, err := firstTitle(cmd)
+
+			So(err, ShouldBeNil)
+			So(title, ShouldEqual, "image/png 192×108")
+			So(getMethods, ShouldResemble, []string{"HEAD", "GET"})
+		})
+
 		Convey("if the url doesn't have a protocol", func() {
 			noProtocolURL := "google.com"
 
-			extractedURL := extractURL(fmt.Sprintf("foo %s bar", noProtocolURL))
+			extractedURLs := extractURLs(fmt.Sprintf("foo %s bar", noProtocolURL))
 
-			So(extractedURL, ShouldEqual, "http://google.com")
+			So(extractedURLs, ShouldResemble, []string{"http://google.com"})
 		})
 
 		Convey("if the text has fewer than 4 characters", func() {
-			So(extractURL("a.a"), ShouldEqual, "")
+			So(extractURLs("a.a"), ShouldBeEmpty)
 		})
 
 		Convey("if the url is invalid", func() {
-			So(extractURL(":googlecom"), ShouldEqual, "")
+			So(extractURLs(":googlecom"), ShouldBeEmpty)
+		})
+
+	})
+}
+
+func TestURLPolicy(t *testing.T) {
+	cmd := &bot.PassiveCmd{Channel: "#channel"}
+	getExecuted := false
+
+	ts := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			getExecuted = true
+			if r.URL.Path == "/redirect" {
+				http.Redirect(w, r, r.URL.Query().Get("to"), http.StatusFound)
+				return
+			}
+			fmt.Fprintln(w, "<title>Internal</title>")
+		}))
+	defer ts.Close()
+	tsURL, _ := neturl.Parse(ts.URL)
+
+	Convey("Given a link to a loopback address", t, func() {
+		cmd.Raw = ts.URL
+
+		Reset(func() {
+			getExecuted = false
+			allowPrivateAddresses = false
+			channelConfigs = nil
+			resetState()
+		})
+
+		Convey("The guard refuses to connect", func() {
+			_, err := describeURL(context.Background(), ts.URL)
+
+			So(errors.Is(err, errForbiddenAddress), ShouldBeTrue)
+			So(getExecuted, ShouldBeFalse)
+		})
+
+		Convey("The guard refuses names resolving to it", func() {
+			_, err := describeURL(context.Background(),
+				"http://localhost:"+tsURL.Port())
+
+			So(errors.Is(err, errForbiddenAddress), ShouldBeTrue)
+			So(getExecuted, ShouldBeFalse)
+		})
+
+		Convey("The page is described when private addresses are allowed", func() {
+			allowPrivateAddresses = true
+
+			title, err := firstTitle(cmd)
+
+			So(err, ShouldBeNil)
+			So(title, ShouldEqual, "Internal")
+		})
+
+		Convey("Denied domains are not fetched", func() {
+			allowPrivateAddresses = true
+			channelConfigs = map[string]*channelConfig{
+				"#channel": {Channel: "#channel", Deny: []string{"127.0.0.1"}},
+			}
+
+			title, err := firstTitle(cmd)
+
+			So(err, ShouldBeNil)
+			So(title, ShouldBeBlank)
+			So(getExecuted, ShouldBeFalse)
+		})
+
+		Convey("Domains outside of allow list are not fetched", func() {
+			allowPrivateAddresses = true
+			channelConfigs = map[string]*channelConfig{
+				defaultChannel: {Channel: defaultChannel, Allow: []string{"golang.org"}},
+			}
+
+			title, err := firstTitle(cmd)
+
+			So(err, ShouldBeNil)
+			So(title, ShouldBeBlank)
+			So(getExecuted, ShouldBeFalse)
+		})
+
+		Convey("Internal hosts are not fetched in external only channels", func() {
+			allowPrivateAddresses = true
+			channelConfigs = map[string]*channelConfig{
+				"#channel": {Channel: "#channel", ExternalOnly: true},
+			}
+
+			title, err := firstTitle(cmd)
+
+			So(err, ShouldBeNil)
+			So(title, ShouldBeBlank)
+			So(getExecuted, ShouldBeFalse)
+		})
+
+		Convey("Redirects to denied domains are not followed", func() {
+			allowPrivateAddresses = true
+			policy := &channelConfig{Deny: []string{"localhost"}}
+			ctx := withPolicy(context.Background(), policy)
+			target := "http://localhost:" + tsURL.Port() + "/"
+
+			_, err := describeURL(ctx, ts.URL+"/redirect?to="+neturl.QueryEscape(target))
+
+			So(errors.Is(err, errDomainNotAllowed), ShouldBeTrue)
+		})
+
+		Convey("Cached descriptions don't bypass the policy of the channel", func() {
+			allowPrivateAddresses = true
+			channelConfigs = map[string]*channelConfig{
+				"#channel": {Channel: "#channel", Deny: []string{"localhost"}},
+			}
+			target := "http://localhost:" + tsURL.Port() + "/"
+			cmd.Raw = ts.URL + "/redirect?to=" + neturl.QueryEscape(target)
+
+			title, err := firstTitle(&bot.PassiveCmd{Channel: "#open", Raw: cmd.Raw})
+			So(err, ShouldBeNil)
+			So(title, ShouldEqual, "Internal")
+
+			title, err = firstTitle(cmd)
+			So(err, ShouldBeNil)
+			So(title, ShouldBeBlank)
+		})
+	})
+
+	Convey("Given an IP address", t, func() {
+		cases := map[string]bool{
+			"8.8.8.8":         true,
+			"2606:4700::1111": true,
+			"127.0.0.1":       false,
+			"10.1.2.3":        false,
+			"172.16.0.1":      false,
+			"192.168.1.1":     false,
+			"169.254.169.254": false,
+			"100.64.0.1":      false,
+			"0.0.0.0":         false,
+			"::1":             false,
+			"fe80::1":         false,
+			"fd00::1":         false,
+			"::ffff:10.0.0.1": false,
+		}
+		for ip, public := range cases {
+			So(isPublicIP(net.ParseIP(ip)), ShouldEqual, public)
+		}
+	})
+
+	Convey("Given a host name", t, func() {
+		So(isInternalHost("jenkins"), ShouldBeTrue)
+		So(isInternalHost("printer.local"), ShouldBeTrue)
+		So(isInternalHost("10.0.0.1"), ShouldBeTrue)
+		So(isInternalHost("golang.org"), ShouldBeFalse)
+	})
+
+	Convey("Given the example configuration", t, func() {
+		err := loadChannelConfigs("example_config.json")
+		Reset(func() {
+			channelConfigs = nil
+		})
+		parse := func(URL string) *neturl.URL {
+			parsedURL, _ := neturl.Parse(URL)
+			return parsedURL
+		}
+
+		So(err, ShouldBeNil)
+		So(getChannelConfig("#random").allowed(parse("http://www.example.com")), ShouldBeFalse)
+		So(getChannelConfig("#random").allowed(parse("http://wiki")), ShouldBeFalse)
+		So(getChannelConfig("#random").allowed(parse("https://golang.org")), ShouldBeTrue)
+		So(getChannelConfig("#ops").allowed(parse("http://wiki")), ShouldBeTrue)
+		So(getChannelConfig("#ops").allowed(parse("https://vault.corp.example.org/x")), ShouldBeFalse)
+		So(getChannelConfig("#golang").allowed(parse("https://pkg.go.dev")), ShouldBeTrue)
+		So(getChannelConfig("#golang").allowed(parse("https://google.com")), ShouldBeFalse)
+	})
+}
+
+func TestURLHistory(t *testing.T) {
+	allowPrivateAddresses = true
+	defer func() { allowPrivateAddresses = false }()
+
+	fetches := 0
+	ts := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == "GET" {
+				fetches++
+			}
+			fmt.Fprintln(w, "<title>Gophers</title>")
+		}))
+	defer ts.Close()
+
+	alice := &bot.PassiveCmd{Channel: "#go", User: &bot.User{Nick: "alice"}}
+	bob := &bot.PassiveCmd{Channel: "#go", User: &bot.User{Nick: "bob"}}
+
+	Convey("Given links posted to a channel", t, func() {
+		Reset(func() {
+			fetches = 0
+			resetState()
+		})
+		alice.Raw = "look " + ts.URL + "/page?utm_source=chat"
+		bob.Raw = "did you see " + ts.URL + "/page#top"
+
+		Convey("The first post is described", func() {
+			title, err := firstTitle(alice)
+
+			So(err, ShouldBeNil)
+			So(title, ShouldEqual, "Gophers")
+		})
+
+		Convey("A repost by someone else mentions the first post", func() {
+			firstTitle(alice)
+
+			title, err := firstTitle(bob)
+
+			So(err, ShouldBeNil)
+			So(title, ShouldEqual, "Gophers (already posted by alice just now)")
+			So(fetches, ShouldEqual, 1)
+		})
+
+		Convey("A repost by the same person is not pointed out", func() {
+			firstTitle(alice)
+
+			title, err := firstTitle(alice)
+
+			So(err, ShouldBeNil)
+			So(title, ShouldEqual, "Gophers")
 		})
 
+		Convey("A repost in another channel is not pointed out", func() {
+			firstTitle(alice)
+			bob.Channel = "#random"
+			Reset(func() {
+				bob.Channel = "#go"
+			})
+
+			title, err := firstTitle(bob)
+
+			So(err, ShouldBeNil)
+			So(title, ShouldEqual, "Gophers")
+			So(fetches, ShouldEqual, 1)
+		})
+
+		Convey("Links command lists recent links", func() {
+			firstTitle(alice)
+			firstTitle(bob)
+			cmd := &bot.Cmd{Channel: "#go", Args: []string{"1"}}
+
+			So(recentLinks(cmd), ShouldResemble, []string{
+				"bob just now: " + ts.URL + "/page#top - Gophers"})
+
+			cmd.Args = []string{}
+			So(recentLinks(cmd), ShouldHaveLength, 2)
+
+			cmd.Args = []string{"many"}
+			So(recentLinks(cmd), ShouldResemble, []string{linksUsage})
+
+			cmd.Channel = "#random"
+			cmd.Args = []string{}
+			So(recentLinks(cmd), ShouldResemble, []string{
+				"No links were posted in this channel yet"})
+		})
+	})
+
+	Convey("Given a URL", t, func() {
+		cases := map[string]string{
+			"HTTP://Example.COM:80/":                         "http://example.com",
+			"https://example.com:443/a?b=2&a=1#frag":         "https://example.com/a?a=1&b=2",
+			"https://example.com/?utm_source=x&utm_medium=y": "https://example.com",
+			"https://example.com/watch?v=1&fbclid=abc":       "https://example.com/watch?v=1",
+			"http://example.com:8080/":                       "http://example.com:8080",
+		}
+		for URL, expected := range cases {
+			parsedURL, _ := neturl.Parse(URL)
+			So(normalizeURL(parsedURL), ShouldEqual, expected)
+		}
+	})
+
+	Convey("Given a description cache", t, func() {
+		cache := newDescriptionCache(2, time.Hour)
+
+		Convey("Least recently used entries are evicted", func() {
+			cache.put("a", "A")
+			cache.put("b", "B")
+			cache.get("a")
+			cache.put("c", "C")
+
+			_, foundA := cache.get("a")
+			_, foundB := cache.get("b")
+			description, foundC := cache.get("c")
+			So(foundA, ShouldBeTrue)
+			So(foundB, ShouldBeFalse)
+			So(foundC, ShouldBeTrue)
+			So(description, ShouldEqual, "C")
+		})
+
+		Convey("Entries expire", func() {
+			cache.ttl = -time.Second
+			cache.put("a", "A")
+
+			_, found := cache.get("a")
+			So(found, ShouldBeFalse)
+		})
+	})
+
+	Convey("Given a duration", t, func() {
+		So(formatAge(30*time.Second), ShouldEqual, "just now")
+		So(formatAge(5*time.Minute), ShouldEqual, "5m ago")
+		So(formatAge(150*time.Minute), ShouldEqual, "2h ago")
+		So(formatAge(50*time.Hour), ShouldEqual, "2d ago")
 	})
 }
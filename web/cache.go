@@ -0,0 +1,280 @@
+package web
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	cacheDirEnv           = "WEB_CACHE_DIR"
+	defaultMaxCachedItems = 512
+)
+
+// DefaultCache is used by clients without their own Cache. It keeps
+// responses in memory and also in the directory set by WEB_CACHE_DIR env
+// variable, if any.
+var DefaultCache = NewCache(os.Getenv(cacheDirEnv))
+
+// CacheStats counts requests with Cached option
+type CacheStats struct {
+	Hits        int64 // served from the cache without asking the server
+	Revalidated int64 // served from the cache after the server said it has not changed
+	Misses      int64 // fetched from the server
+	Entries     int   // responses kept in memory
+}
+
+// cacheEntry is a cached response
+type cacheEntry struct {
+	Key          string    `json:"key"` // URL unless CacheKey option was used
+	Body         []byte    `json:"body"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"lastModified,omitempty"`
+	Expires      time.Time `json:"expires"` // fresh until
+	stored       time.Time
+}
+
+// Cache keeps responses of GET requests in memory and optionally on disk.
+// Responses are fresh as long as Cache-Control or Expires headers say, stale
+// responses with ETag or Last-Modified are revalidated with the server.
+type Cache struct {
+	mu         sync.Mutex
+	dir        string // no disk cache if empty
+	maxEntries int    // in memory
+	entries    map[string]*cacheEntry
+	stats      CacheStats
+}
+
+// NewCache returns a cache keeping responses in memory and in dir, if it is
+// not empty
+func NewCache(dir string) *Cache {
+	return &Cache{
+		dir:        dir,
+		maxEntries: defaultMaxCachedItems,
+		entries:    make(map[string]*cacheEntry),
+	}
+}
+
+// Stats returns counts of hits and misses of the cache
+func (c *Cache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Entries = len(c.entries)
+	return stats
+}
+
+// Clear forgets all responses kept in memory and on disk and resets stats
+func (c *Cache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]*cacheEntry)
+	c.stats = CacheStats{}
+	if c.dir == "" {
+		return
+	}
+	files, _ := filepath.Glob(filepath.Join(c.dir, "*.json"))
+	for _, file := range files {
+		os.Remove(file)
+	}
+}
+
+func (e *cacheEntry) hasValidators() bool {
+	return e.ETag != "" || e.LastModified != ""
+}
+
+// cacheControl parses Cache-Control header into directive -> value map
+func cacheControl(header http.Header) map[string]string {
+	directives := make(map[string]string)
+	for _, value := range header.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			name, arg, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			directives[strings.ToLower(name)] = strings.Trim(arg, `"`)
+		}
+	}
+	return directives
+}
+
+// freshUntil returns time until which the response is fresh according to
+// its headers, ttl is used when headers don't say. store is false for
+// responses which must not be cached.
+func freshUntil(header http.Header, now time.Time, ttl time.Duration) (until time.Time, store bool) {
+	directives := cacheControl(header)
+	if _, found := directives["no-store"]; found {
+		return now, false
+	}
+	if _, found := directives["no-cache"]; found {
+		return now, true
+	}
+	if value, found := directives["max-age"]; found {
+		maxAge, err := strconv.Atoi(value)
+		if err != nil {
+			return now, true
+		}
+		age, _ := strconv.Atoi(header.Get("Age"))
+		return now.Add(time.Duration(maxAge-age) * time.Second), true
+	}
+	if value := header.Get("Expires"); value != "" {
+		expires, err := http.ParseTime(value)
+		if err != nil {
+			// invalid dates such as 0 mean already expired
+			return now, true
+		}
+		// trust the server's clock relatively to its Date header
+		if date, err := http.ParseTime(header.Get("Date")); err == nil {
+			return now.Add(expires.Sub(date)), true
+		}
+		return expires, true
+	}
+	return now.Add(ttl), true
+}
+
+func (c *Cache) filename(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return filepath.Join(c.dir, hex.EncodeToString(hash[:])+".json")
+}
+
+// lookup returns entry of key from memory or disk, nil if there is none.
+// Cache must be locked.
+func (c *Cache) lookup(key string) *cacheEntry {
+	if entry, found := c.entries[key]; found {
+		return entry
+	}
+	if c.dir == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(c.filename(key))
+	if err != nil {
+		return nil
+	}
+	entry := &cacheEntry{}
+	if err := json.Unmarshal(data, entry); err != nil || entry.Key != key {
+		return nil
+	}
+	entry.stored = time.Now()
+	c.remember(entry)
+	return entry
+}
+
+// remember keeps entry in memory, making room for it by forgetting useless
+// entries or the oldest one. Cache must be locked.
+func (c *Cache) remember(entry *cacheEntry) {
+	if _, found := c.entries[entry.Key]; !found && len(c.entries) >= c.maxEntries {
+		now := time.Now()
+		var oldest *cacheEntry
+		for key, e := range c.entries {
+			if now.After(e.Expires) && !e.hasValidators() {
+				delete(c.entries, key)
+			} else if oldest == nil || e.stored.Before(oldest.stored) {
+				oldest = e
+			}
+		}
+		if len(c.entries) >= c.maxEntries && oldest != nil {
+			delete(c.entries, oldest.Key)
+		}
+	}
+	c.entries[entry.Key] = entry
+}
+
+// store keeps entry in memory and on disk. Cache must be locked.
+func (c *Cache) store(entry *cacheEntry) {
+	entry.stored = time.Now()
+	c.remember(entry)
+	if c.dir == "" {
+		return
+	}
+	data, err := json.Marshal(entry)
+	if err == nil {
+		err = os.MkdirAll(c.dir, 0700)
+	}
+	if err == nil {
+		filename := c.filename(entry.Key)
+		if err = ioutil.WriteFile(filename+".tmp", data, 0600); err == nil {
+			err = os.Rename(filename+".tmp", filename)
+		}
+	}
+	if err != nil {
+		log.Printf("Failed to store %s in web cache: %v", withoutQuery(entry.Key), err)
+	}
+}
+
+// forget removes entry of key from memory and disk. Cache must be locked.
+func (c *Cache) forget(key string) {
+	delete(c.entries, key)
+	if c.dir != "" {
+		os.Remove(c.filename(key))
+	}
+}
+
+// get returns body cached under key if it is fresh, otherwise it gets
+// it using fetch, revalidating the cached response if possible. The cache
+// is not locked during fetch, concurrent requests of the same key may both
+// go to the server.
+func (c *Cache) get(key string, ttl time.Duration, fetch func(http.Header) (*response, error)) ([]byte, error) {
+	c.mu.Lock()
+	entry := c.lookup(key)
+	if entry != nil && time.Now().Before(entry.Expires) {
+		c.stats.Hits++
+		c.mu.Unlock()
+		return entry.Body, nil
+	}
+	c.mu.Unlock()
+
+	header := http.Header{}
+	if entry != nil {
+		if entry.ETag != "" {
+			header.Set("If-None-Match", entry.ETag)
+		}
+		if entry.LastModified != "" {
+			header.Set("If-Modified-Since", entry.LastModified)
+		}
+	}
+	res, err := fetch(header)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		c.stats.Misses++
+		return nil, err
+	}
+	now := time.Now()
+	until, store := freshUntil(res.header, now, ttl)
+	if res.statusCode == http.StatusNotModified && entry != nil {
+		c.stats.Revalidated++
+		// entries are never modified as they may be read without lock
+		updated := *entry
+		updated.Expires = until
+		if etag := res.header.Get("ETag"); etag != "" {
+			updated.ETag = etag
+		}
+		if store {
+			c.store(&updated)
+		} else {
+			c.forget(key)
+		}
+		return entry.Body, nil
+	}
+	c.stats.Misses++
+	fresh := &cacheEntry{
+		Key:          key,
+		Body:         res.body,
+		ETag:         res.header.Get("ETag"),
+		LastModified: res.header.Get("Last-Modified"),
+		Expires:      until,
+	}
+	if store && (now.Before(until) || fresh.hasValidators()) {
+		c.store(fresh)
+	} else {
+		c.forget(key)
+	}
+	return res.body, nil
+}
@@ -0,0 +1,142 @@
+package web
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCache(t *testing.T) {
+	Convey("Given a web server with cacheable responses", t, func() {
+		var requests int32
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&requests, 1)
+			switch r.URL.Path {
+			case "/max-age":
+				w.Header().Set("Cache-Control", "public, max-age=60")
+			case "/expired":
+				w.Header().Set("Cache-Control", "max-age=0")
+			case "/expires":
+				w.Header().Set("Date", "Mon, 01 Jun 2020 10:00:00 GMT")
+				w.Header().Set("Expires", "Mon, 01 Jun 2020 10:01:00 GMT")
+			case "/no-store":
+				w.Header().Set("Cache-Control", "no-store")
+			case "/etag":
+				w.Header().Set("Cache-Control", "no-cache")
+				w.Header().Set("ETag", `"v1"`)
+				if r.Header.Get("If-None-Match") == `"v1"` {
+					w.WriteHeader(http.StatusNotModified)
+					return
+				}
+			case "/last-modified":
+				w.Header().Set("Last-Modified", "Mon, 01 Jun 2020 10:00:00 GMT")
+				if r.Header.Get("If-Modified-Since") != "" {
+					w.WriteHeader(http.StatusNotModified)
+					return
+				}
+			}
+			w.Write([]byte(r.URL.Path))
+		}))
+		Reset(ts.Close)
+		dir, _ := ioutil.TempDir("", "web")
+		Reset(func() { os.RemoveAll(dir) })
+		cache := NewCache(dir)
+		client := &Client{HTTPClient: ts.Client(), Cache: cache}
+		get := func(path string, options ...Option) string {
+			body, err := client.GetBody(context.Background(), ts.URL+path, options...)
+			So(err, ShouldBeNil)
+			return string(body)
+		}
+
+		Convey("Fresh responses are served from the cache", func() {
+			So(get("/max-age", Cached(0)), ShouldEqual, "/max-age")
+			So(get("/max-age", Cached(0)), ShouldEqual, "/max-age")
+			So(requests, ShouldEqual, 1)
+			So(cache.Stats(), ShouldResemble, CacheStats{Hits: 1, Misses: 1, Entries: 1})
+		})
+
+		Convey("Caching is opt in", func() {
+			get("/max-age")
+			get("/max-age")
+			So(requests, ShouldEqual, 2)
+			So(cache.Stats(), ShouldResemble, CacheStats{})
+		})
+
+		Convey("Expires is relative to Date of the server", func() {
+			get("/expires", Cached(0))
+			get("/expires", Cached(0))
+			So(requests, ShouldEqual, 1)
+		})
+
+		Convey("Expired and no-store responses are fetched again", func() {
+			get("/expired", Cached(time.Hour))
+			get("/expired", Cached(time.Hour))
+			get("/no-store", Cached(time.Hour))
+			get("/no-store", Cached(time.Hour))
+			So(requests, ShouldEqual, 4)
+			So(cache.Stats().Entries, ShouldEqual, 0)
+		})
+
+		Convey("Responses are revalidated with ETag and Last-Modified", func() {
+			So(get("/etag", Cached(0)), ShouldEqual, "/etag")
+			So(get("/etag", Cached(0)), ShouldEqual, "/etag")
+			So(get("/last-modified", Cached(0)), ShouldEqual, "/last-modified")
+			So(get("/last-modified", Cached(0)), ShouldEqual, "/last-modified")
+			So(requests, ShouldEqual, 4)
+			So(cache.Stats(), ShouldResemble, CacheStats{Revalidated: 2, Misses: 2, Entries: 2})
+		})
+
+		Convey("TTL applies to responses without freshness headers", func() {
+			get("/plain", Cached(time.Hour))
+			get("/plain", Cached(time.Hour))
+			So(requests, ShouldEqual, 1)
+		})
+
+		Convey("Responses are kept on disk", func() {
+			get("/max-age", Cached(0))
+			other := &Client{HTTPClient: ts.Client(), Cache: NewCache(dir)}
+			body, err := other.GetBody(context.Background(), ts.URL+"/max-age", Cached(0))
+			So(err, ShouldBeNil)
+			So(string(body), ShouldEqual, "/max-age")
+			So(requests, ShouldEqual, 1)
+
+			cache.Clear()
+			get("/max-age", Cached(0))
+			So(requests, ShouldEqual, 2)
+		})
+
+		Convey("Credentials in the URL are kept out of the cache with CacheKey", func() {
+			get("/max-age?key=secret", Cached(0), CacheKey(ts.URL+"/max-age"))
+			So(get("/max-age?key=other", Cached(0), CacheKey(ts.URL+"/max-age")), ShouldEqual, "/max-age")
+			So(requests, ShouldEqual, 1)
+
+			files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+			So(files, ShouldHaveLength, 1)
+			data, _ := ioutil.ReadFile(files[0])
+			So(string(data), ShouldNotContainSubstring, "secret")
+		})
+
+		Convey("Logged URLs have no query", func() {
+			So(withoutQuery("https://api.example.com/search?api_key=secret&q=cat"),
+				ShouldEqual, "https://api.example.com/search")
+		})
+
+		Convey("Memory is limited", func() {
+			cache = NewCache("")
+			cache.maxEntries = 2
+			client.Cache = cache
+			get("/max-age?1", Cached(0))
+			get("/max-age?2", Cached(0))
+			get("/max-age?3", Cached(0))
+			So(cache.Stats().Entries, ShouldEqual, 2)
+		})
+	})
+}
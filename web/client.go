@@ -0,0 +1,288 @@
+// Package web fetches web resources for plugins. Requests time out, are
+// retried when the failure is likely temporary, identify the bot by
+// User-Agent and bodies are capped in size.
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	defaultTimeout     = 10 * time.Second
+	defaultMaxBodySize = 2 * 1024 * 1024 // bytes
+	defaultRetries     = 2
+	defaultRetryWait   = 200 * time.Millisecond
+	defaultUserAgent   = "go-chat-bot (+https://github.com/go-chat-bot/plugins)"
+)
+
+// defaultRateLimit of DefaultClient keeps a flood of commands from flooding
+// remote services
+var defaultRateLimit = RateLimit{PerSecond: 2, Burst: 10}
+
+// Client fetches web resources. The zero value is ready to use, zero fields
+// are replaced by defaults.
+type Client struct {
+	// HTTPClient sends the requests, http.DefaultClient if nil
+	HTTPClient  *http.Client
+	UserAgent   string
+	Timeout     time.Duration // of a single attempt, 10s by default
+	MaxBodySize int64         // bytes, 2 MB by default
+	// Retries of failed GET requests, 2 by default, negative disables retries
+	Retries int
+	// RetryWait is the base of exponential backoff between retries, 200ms by
+	// default. Actual waits are randomized so that retries of many requests
+	// don't hit the server at once.
+	RetryWait time.Duration
+	// Cache keeps responses of requests with Cached option, DefaultCache if
+	// nil
+	Cache *Cache
+	// RateLimit applies to each host not in HostRateLimits, requests which
+	// would wait for the limit longer than Timeout fail with *RateLimitError
+	RateLimit      RateLimit
+	HostRateLimits map[string]RateLimit // host (with port if any) -> RateLimit
+	// BreakerFailures is number of failures in a row after which requests to
+	// the host fail with *CircuitOpenError for BreakerCooldown. 5 failures
+	// and 30s by default, negative disables the breaker.
+	BreakerFailures int
+	BreakerCooldown time.Duration
+
+	mu    sync.Mutex
+	hosts map[string]*hostState
+}
+
+// Option changes a single request
+type Option func(*requestOptions)
+
+type requestOptions struct {
+	cached   bool
+	ttl      time.Duration
+	cacheKey string
+}
+
+// Cached lets the response be served from and stored in the cache of the
+// client as Cache-Control and Expires headers allow. ttl is used for
+// responses which don't say how long they are fresh, zero means such
+// responses are only reused after revalidation with ETag or Last-Modified.
+func Cached(ttl time.Duration) Option {
+	return func(opts *requestOptions) {
+		opts.cached = true
+		opts.ttl = ttl
+	}
+}
+
+// CacheKey makes a request with Cached option cached under key instead of
+// its URL. Requests with credentials such as API keys in the URL should use
+// a key without them, cache keys are stored in WEB_CACHE_DIR in plain text.
+func CacheKey(key string) Option {
+	return func(opts *requestOptions) {
+		opts.cacheKey = key
+	}
+}
+
+// DefaultClient is used by GetBody, GetJSON and their Context variants. It
+// limits requests to each host to defaultRateLimit, plugins set limits of
+// the APIs they use with SetHostRateLimit.
+var DefaultClient = &Client{RateLimit: defaultRateLimit}
+
+// StatusError is returned when the server responds with other than 2xx status
+type StatusError struct {
+	URL        string // without query, which may contain credentials
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("request for %s returned code: %d", e.URL, e.StatusCode)
+}
+
+// Temporary reports whether repeating the request later may succeed
+func (e *StatusError) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// TooLargeError is returned when the body is bigger than MaxBodySize
+type TooLargeError struct {
+	URL   string // without query
+	Limit int64
+}
+
+func (e *TooLargeError) Error() string {
+	return fmt.Sprintf("response of %s is larger than %d bytes", e.URL, e.Limit)
+}
+
+// SetHTTPClient sets client used to send requests of DefaultClient, e.g. to
+// point plugins to a test server. Nil restores http.DefaultClient.
+func SetHTTPClient(client *http.Client) {
+	DefaultClient.HTTPClient = client
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient == nil {
+		return http.DefaultClient
+	}
+	return c.HTTPClient
+}
+
+func (c *Client) userAgent() string {
+	if c.UserAgent == "" {
+		return defaultUserAgent
+	}
+	return c.UserAgent
+}
+
+func (c *Client) timeout() time.Duration {
+	if c.Timeout <= 0 {
+		return defaultTimeout
+	}
+	return c.Timeout
+}
+
+func (c *Client) maxBodySize() int64 {
+	if c.MaxBodySize <= 0 {
+		return defaultMaxBodySize
+	}
+	return c.MaxBodySize
+}
+
+func (c *Client) retries() int {
+	switch {
+	case c.Retries < 0:
+		return 0
+	case c.Retries == 0:
+		return defaultRetries
+	}
+	return c.Retries
+}
+
+// backoff returns wait before retry number attempt (counted from 0), a random
+// duration between half and whole of RetryWait * 2^attempt
+func (c *Client) backoff(attempt int) time.Duration {
+	wait := c.RetryWait
+	if wait <= 0 {
+		wait = defaultRetryWait
+	}
+	wait <<= uint(attempt)
+	return wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+}
+
+// response of a successful request
+type response struct {
+	statusCode int
+	header     http.Header
+	body       []byte
+}
+
+// getOnce makes a single attempt to get url, retry reports whether the
+// failure is worth another attempt. 304 is a success as it only comes back
+// for conditional requests.
+func (c *Client) getOnce(ctx context.Context, url string, header http.Header) (res *response, retry bool, err error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, false, redactError(err)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("User-Agent", c.userAgent())
+	httpRes, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, true, redactError(err)
+	}
+	defer httpRes.Body.Close()
+
+	if httpRes.StatusCode == http.StatusNotModified {
+		return &response{statusCode: httpRes.StatusCode, header: httpRes.Header}, false, nil
+	}
+	if httpRes.StatusCode < 200 || httpRes.StatusCode > 299 {
+		// let the connection be reused
+		io.Copy(ioutil.Discard, io.LimitReader(httpRes.Body, 4096))
+		statusErr := &StatusError{URL: withoutQuery(url), StatusCode: httpRes.StatusCode}
+		return nil, statusErr.Temporary(), statusErr
+	}
+	limit := c.maxBodySize()
+	body, err := ioutil.ReadAll(io.LimitReader(httpRes.Body, limit+1))
+	if err != nil {
+		return nil, true, err
+	}
+	if int64(len(body)) > limit {
+		return nil, false, &TooLargeError{URL: withoutQuery(url), Limit: limit}
+	}
+	return &response{statusCode: httpRes.StatusCode, header: httpRes.Header, body: body}, false, nil
+}
+
+// get requests url with additional header. Failed connections, 429 and 5xx
+// responses are retried, other statuses than 2xx and 304 are reported as
+// *StatusError. Each attempt is subject to rate limit and circuit breaker of
+// the host, but the request counts as a single failure of the breaker once
+// its last attempt failed.
+func (c *Client) get(ctx context.Context, url string, header http.Header) (*response, error) {
+	host := hostOf(url)
+	for attempt := 0; ; attempt++ {
+		if err := c.admit(ctx, host); err != nil {
+			return nil, err
+		}
+		res, retry, err := c.getOnce(ctx, url, header)
+		last := err == nil || !retry || attempt >= c.retries() || ctx.Err() != nil
+		if last && ctx.Err() == nil {
+			c.done(host, err != nil && retry)
+		} else {
+			c.release(host)
+		}
+		if last {
+			return res, err
+		}
+		timer := time.NewTimer(c.backoff(attempt))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, err
+		}
+	}
+}
+
+// GetBody returns the body of url. Failed connections, 429 and 5xx
+// responses are retried, other statuses than 2xx are reported as
+// *StatusError. With Cached option the body may come from the cache.
+func (c *Client) GetBody(ctx context.Context, url string, options ...Option) ([]byte, error) {
+	opts := &requestOptions{}
+	for _, option := range options {
+		option(opts)
+	}
+	if !opts.cached {
+		res, err := c.get(ctx, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		return res.body, nil
+	}
+	cache := c.Cache
+	if cache == nil {
+		cache = DefaultCache
+	}
+	key := url
+	if opts.cacheKey != "" {
+		key = opts.cacheKey
+	}
+	return cache.get(key, opts.ttl, func(header http.Header) (*response, error) {
+		return c.get(ctx, url, header)
+	})
+}
+
+// GetJSON decodes JSON body of url into v
+func (c *Client) GetJSON(ctx context.Context, url string, v interface{}, options ...Option) error {
+	body, err := c.GetBody(ctx, url, options...)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, v)
+}
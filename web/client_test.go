@@ -0,0 +1,124 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestClient(t *testing.T) {
+	Convey("Given a web server", t, func() {
+		var requests int32
+		var userAgent atomic.Value
+		failures := int32(0) // number of requests answered with 503
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			n := atomic.AddInt32(&requests, 1)
+			userAgent.Store(r.UserAgent())
+			switch r.URL.Path {
+			case "/json":
+				if n <= atomic.LoadInt32(&failures) {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				w.Write([]byte(`{"name": "bot"}`))
+			case "/missing":
+				http.NotFound(w, r)
+			case "/large":
+				w.Write([]byte(strings.Repeat("x", 100)))
+			case "/slow":
+				time.Sleep(200 * time.Millisecond)
+			}
+		}))
+		Reset(ts.Close)
+		client := &Client{HTTPClient: ts.Client(), RetryWait: time.Millisecond}
+		var data struct{ Name string }
+
+		Convey("JSON is decoded", func() {
+			So(client.GetJSON(context.Background(), ts.URL+"/json", &data), ShouldBeNil)
+			So(data.Name, ShouldEqual, "bot")
+			So(userAgent.Load(), ShouldEqual, defaultUserAgent)
+		})
+
+		Convey("Temporary failures are retried", func() {
+			failures = 2
+			So(client.GetJSON(context.Background(), ts.URL+"/json", &data), ShouldBeNil)
+			So(requests, ShouldEqual, 3)
+		})
+
+		Convey("Retries give up", func() {
+			failures = 10
+			err := client.GetJSON(context.Background(), ts.URL+"/json", &data)
+			statusErr, ok := err.(*StatusError)
+			So(ok, ShouldBeTrue)
+			So(statusErr.StatusCode, ShouldEqual, http.StatusServiceUnavailable)
+			So(requests, ShouldEqual, 3)
+		})
+
+		Convey("Other statuses are not retried", func() {
+			_, err := client.GetBody(context.Background(), ts.URL+"/missing")
+			So(err, ShouldResemble, &StatusError{URL: ts.URL + "/missing", StatusCode: 404})
+			So(requests, ShouldEqual, 1)
+		})
+
+		Convey("Errors don't contain the query, which may hold credentials", func() {
+			_, err := client.GetBody(context.Background(), ts.URL+"/missing?api_key=secret")
+			So(err, ShouldResemble, &StatusError{URL: ts.URL + "/missing", StatusCode: 404})
+
+			client.MaxBodySize = 10
+			_, err = client.GetBody(context.Background(), ts.URL+"/large?api_key=secret")
+			So(err.Error(), ShouldNotContainSubstring, "secret")
+
+			client.Retries = -1
+			_, err = client.GetBody(context.Background(), "http://127.0.0.1:1/?api_key=secret")
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldNotContainSubstring, "secret")
+		})
+
+		Convey("Bodies are capped", func() {
+			client.MaxBodySize = 10
+			_, err := client.GetBody(context.Background(), ts.URL+"/large")
+			So(err, ShouldResemble, &TooLargeError{URL: ts.URL + "/large", Limit: 10})
+		})
+
+		Convey("Requests time out", func() {
+			client.Timeout = 50 * time.Millisecond
+			client.Retries = -1
+			start := time.Now()
+			_, err := client.GetBody(context.Background(), ts.URL+"/slow")
+			So(err, ShouldNotBeNil)
+			So(time.Since(start), ShouldBeLessThan, 150*time.Millisecond)
+		})
+
+		Convey("Canceled context stops retries", func() {
+			failures = 10
+			client.RetryWait = time.Second
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			_, err := client.GetBody(ctx, ts.URL+"/json")
+			So(err, ShouldHaveSameTypeAs, &StatusError{})
+			So(requests, ShouldEqual, 1)
+		})
+
+		Convey("Default client can be pointed to a test server", func() {
+			SetHTTPClient(ts.Client())
+			defer SetHTTPClient(nil)
+			So(GetJSON(ts.URL+"/json", &data), ShouldBeNil)
+			So(data.Name, ShouldEqual, "bot")
+		})
+	})
+
+	Convey("Backoff is randomized and grows", t, func() {
+		client := &Client{RetryWait: 100 * time.Millisecond}
+		for attempt := 0; attempt < 3; attempt++ {
+			wait := client.backoff(attempt)
+			max := 100 * time.Millisecond << uint(attempt)
+			So(wait, ShouldBeBetweenOrEqual, max/2, max)
+		}
+	})
+}
@@ -0,0 +1,213 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+const (
+	defaultBreakerFailures = 5
+	defaultBreakerCooldown = 30 * time.Second
+)
+
+// RateLimit allows Burst requests at once and then PerSecond requests per
+// second. The zero value means no limit.
+type RateLimit struct {
+	PerSecond float64
+	Burst     int // at least 1
+}
+
+func (limit RateLimit) burst() float64 {
+	if limit.Burst < 1 {
+		return 1
+	}
+	return float64(limit.Burst)
+}
+
+// RateLimitError is returned without sending the request when the rate
+// limit of the host would make the request wait longer than Timeout
+type RateLimitError struct {
+	Host string
+	Wait time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("too many requests to %s, next one is possible in %s",
+		e.Host, e.Wait.Round(time.Millisecond))
+}
+
+// CircuitOpenError is returned without sending the request when requests to
+// the host failed too many times in a row. Requests are tried again after
+// Until.
+type CircuitOpenError struct {
+	Host  string
+	Until time.Time
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("%s is failing, requests are suspended until %s",
+		e.Host, e.Until.Format("15:04:05"))
+}
+
+// IsUnavailable reports whether err means the request wasn't sent because
+// the host is failing or rate limited. Plugins can tell users to try later
+// or, when passive, stay quiet.
+func IsUnavailable(err error) bool {
+	var rateErr *RateLimitError
+	var circuitErr *CircuitOpenError
+	return errors.As(err, &rateErr) || errors.As(err, &circuitErr)
+}
+
+// hostState keeps token bucket and circuit breaker of a host
+type hostState struct {
+	tokens   float64
+	last     time.Time // of tokens update
+	failures int       // in a row
+	openTill time.Time // circuit is open until
+	trial    bool      // a request is testing whether host works again
+}
+
+// SetHostRateLimit sets rate limit of requests of the client to host (with
+// port if any). Unlike HostRateLimits it can be used while the client sends
+// requests.
+func (c *Client) SetHostRateLimit(host string, limit RateLimit) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	limits := make(map[string]RateLimit, len(c.HostRateLimits)+1)
+	for name, hostLimit := range c.HostRateLimits {
+		limits[name] = hostLimit
+	}
+	limits[host] = limit
+	c.HostRateLimits = limits
+	if state, found := c.hosts[host]; found && state.tokens > limit.burst() {
+		state.tokens = limit.burst()
+	}
+}
+
+// SetHostRateLimit sets rate limit of requests of DefaultClient to host, e.g.
+// as the terms of an API require
+func SetHostRateLimit(host string, limit RateLimit) {
+	DefaultClient.SetHostRateLimit(host, limit)
+}
+
+// rateLimit returns limit of host. Client must be locked.
+func (c *Client) rateLimit(host string) RateLimit {
+	if limit, found := c.HostRateLimits[host]; found {
+		return limit
+	}
+	return c.RateLimit
+}
+
+func (c *Client) breakerFailures() int {
+	if c.BreakerFailures == 0 {
+		return defaultBreakerFailures
+	}
+	return c.BreakerFailures
+}
+
+func (c *Client) breakerCooldown() time.Duration {
+	if c.BreakerCooldown <= 0 {
+		return defaultBreakerCooldown
+	}
+	return c.BreakerCooldown
+}
+
+// host returns state of host, creating it if needed. Client must be locked.
+func (c *Client) host(host string) *hostState {
+	if c.hosts == nil {
+		c.hosts = make(map[string]*hostState)
+	}
+	state, found := c.hosts[host]
+	if !found {
+		state = &hostState{tokens: c.rateLimit(host).burst(), last: time.Now()}
+		c.hosts[host] = state
+	}
+	return state
+}
+
+func hostOf(rawURL string) string {
+	if u, err := url.Parse(rawURL); err == nil {
+		return u.Host
+	}
+	return ""
+}
+
+// admit checks circuit breaker of the host and waits for the rate limit. Once
+// admitted the request must be followed by done or release.
+func (c *Client) admit(ctx context.Context, host string) error {
+	c.mu.Lock()
+	state := c.host(host)
+	now := time.Now()
+	if c.breakerFailures() > 0 && now.Before(state.openTill) {
+		c.mu.Unlock()
+		return &CircuitOpenError{Host: host, Until: state.openTill}
+	}
+	if state.failures >= c.breakerFailures() && c.breakerFailures() > 0 {
+		// cooldown is over, let a single request find out if host works
+		if state.trial {
+			c.mu.Unlock()
+			return &CircuitOpenError{Host: host, Until: now.Add(c.timeout())}
+		}
+		state.trial = true
+	}
+
+	var wait time.Duration
+	if limit := c.rateLimit(host); limit.PerSecond > 0 {
+		state.tokens += now.Sub(state.last).Seconds() * limit.PerSecond
+		if state.tokens > limit.burst() {
+			state.tokens = limit.burst()
+		}
+		state.last = now
+		if state.tokens < 1 {
+			wait = time.Duration((1 - state.tokens) / limit.PerSecond * float64(time.Second))
+		}
+		if wait > c.timeout() {
+			state.trial = false
+			c.mu.Unlock()
+			return &RateLimitError{Host: host, Wait: wait}
+		}
+		// tokens go negative so that later requests wait behind this one
+		state.tokens--
+	}
+	c.mu.Unlock()
+
+	if wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			c.release(host)
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// done records result of admitted request to host. Failures count towards
+// opening the circuit, success closes it.
+func (c *Client) done(host string, failed bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	state := c.host(host)
+	state.trial = false
+	if !failed {
+		state.failures = 0
+		return
+	}
+	state.failures++
+	if c.breakerFailures() > 0 && state.failures >= c.breakerFailures() {
+		state.openTill = time.Now().Add(c.breakerCooldown())
+	}
+}
+
+// release lets another request test the host when admitted request was given
+// up by the caller and says nothing about the host
+func (c *Client) release(host string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.host(host).trial = false
+}
@@ -0,0 +1,119 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestLimits(t *testing.T) {
+	Convey("Given a web server", t, func() {
+		var requests int32
+		failing := int32(1)
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&requests, 1)
+			if r.URL.Path == "/missing" {
+				http.NotFound(w, r)
+				return
+			}
+			if atomic.LoadInt32(&failing) == 1 && r.URL.Path == "/fail" {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			w.Write([]byte("ok"))
+		}))
+		Reset(ts.Close)
+		client := &Client{
+			HTTPClient:      ts.Client(),
+			Retries:         -1,
+			BreakerFailures: 3,
+			BreakerCooldown: 100 * time.Millisecond,
+		}
+		get := func(path string) error {
+			_, err := client.GetBody(context.Background(), ts.URL+path)
+			return err
+		}
+
+		Convey("Circuit opens after failures in a row and fails fast", func() {
+			for i := 0; i < 3; i++ {
+				So(get("/fail"), ShouldHaveSameTypeAs, &StatusError{})
+			}
+			err := get("/ok")
+			var circuitErr *CircuitOpenError
+			So(errors.As(err, &circuitErr), ShouldBeTrue)
+			So(circuitErr.Host, ShouldEqual, hostOf(ts.URL))
+			So(IsUnavailable(fmt.Errorf("gif: %w", err)), ShouldBeTrue)
+			So(requests, ShouldEqual, 3)
+
+			Convey("A trial request closes it once the host works again", func() {
+				time.Sleep(150 * time.Millisecond)
+				atomic.StoreInt32(&failing, 0)
+				So(get("/fail"), ShouldBeNil)
+				So(get("/ok"), ShouldBeNil)
+			})
+
+			Convey("A failed trial request opens it again", func() {
+				time.Sleep(150 * time.Millisecond)
+				So(get("/fail"), ShouldHaveSameTypeAs, &StatusError{})
+				So(IsUnavailable(get("/ok")), ShouldBeTrue)
+			})
+		})
+
+		Convey("Success resets the count of failures", func() {
+			So(get("/fail"), ShouldNotBeNil)
+			So(get("/fail"), ShouldNotBeNil)
+			So(get("/ok"), ShouldBeNil)
+			So(get("/fail"), ShouldNotBeNil)
+			So(get("/ok"), ShouldBeNil)
+		})
+
+		Convey("A request counts as one failure however many attempts it took", func() {
+			client.Retries = 2
+			client.RetryWait = time.Millisecond
+			So(get("/fail"), ShouldHaveSameTypeAs, &StatusError{})
+			So(get("/fail"), ShouldHaveSameTypeAs, &StatusError{})
+			So(requests, ShouldEqual, 6)
+			So(get("/ok"), ShouldBeNil)
+		})
+
+		Convey("Client errors don't open the circuit", func() {
+			client.BreakerFailures = 1
+			So(get("/missing"), ShouldHaveSameTypeAs, &StatusError{})
+			So(get("/missing"), ShouldHaveSameTypeAs, &StatusError{})
+		})
+
+		Convey("Requests wait for the rate limit of the host", func() {
+			client.HostRateLimits = map[string]RateLimit{hostOf(ts.URL): {PerSecond: 20, Burst: 2}}
+			start := time.Now()
+			for i := 0; i < 4; i++ {
+				So(get("/ok"), ShouldBeNil)
+			}
+			So(time.Since(start), ShouldBeGreaterThanOrEqualTo, 90*time.Millisecond)
+		})
+
+		Convey("Rate limit of a host can be set while the client is used", func() {
+			So(get("/ok"), ShouldBeNil)
+			client.SetHostRateLimit(hostOf(ts.URL), RateLimit{PerSecond: 1})
+			client.Timeout = 100 * time.Millisecond
+			So(get("/ok"), ShouldBeNil)
+			So(get("/ok"), ShouldHaveSameTypeAs, &RateLimitError{})
+		})
+
+		Convey("Requests which would wait too long fail fast", func() {
+			client.RateLimit = RateLimit{PerSecond: 1}
+			client.Timeout = 100 * time.Millisecond
+			So(get("/ok"), ShouldBeNil)
+			err := get("/ok")
+			So(err, ShouldHaveSameTypeAs, &RateLimitError{})
+			So(IsUnavailable(err), ShouldBeTrue)
+			So(requests, ShouldEqual, 1)
+		})
+	})
+}
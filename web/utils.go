@@ -1,25 +1,47 @@
 package web
 
 import (
-	"encoding/json"
-	"io/ioutil"
-	"net/http"
+	"context"
+	"errors"
+	neturl "net/url"
 )
 
-func GetBody(url string) ([]byte, error) {
-	res, err := http.Get(url)
-	if err != nil {
-		return nil, err
+// withoutQuery returns rawURL without its query, which may contain
+// credentials
+func withoutQuery(rawURL string) string {
+	if u, err := neturl.Parse(rawURL); err == nil {
+		u.RawQuery = ""
+		return u.String()
 	}
-	defer res.Body.Close()
-
-	return ioutil.ReadAll(res.Body)
+	return "(invalid URL)"
 }
 
-func GetJSON(url string, v interface{}) error {
-	body, err := GetBody(url)
-	if err != nil {
-		return err
+// redactError removes query of the URL from err returned by http.Client, so
+// that credentials don't end up in logs or chat when plugins pass it on
+func redactError(err error) error {
+	var urlErr *neturl.Error
+	if errors.As(err, &urlErr) {
+		urlErr.URL = withoutQuery(urlErr.URL)
 	}
-	return json.Unmarshal(body, v)
+	return err
+}
+
+// GetBody returns the body of url using DefaultClient
+func GetBody(url string, options ...Option) ([]byte, error) {
+	return DefaultClient.GetBody(context.Background(), url, options...)
+}
+
+// GetBodyContext is GetBody which gives up when ctx is done
+func GetBodyContext(ctx context.Context, url string, options ...Option) ([]byte, error) {
+	return DefaultClient.GetBody(ctx, url, options...)
+}
+
+// GetJSON decodes JSON body of url into v using DefaultClient
+func GetJSON(url string, v interface{}, options ...Option) error {
+	return DefaultClient.GetJSON(context.Background(), url, v, options...)
+}
+
+// GetJSONContext is GetJSON which gives up when ctx is done
+func GetJSONContext(ctx context.Context, url string, v interface{}, options ...Option) error {
+	return DefaultClient.GetJSON(ctx, url, v, options...)
 }
@@ -0,0 +1,232 @@
+// Package webtest replays recorded HTTP interactions (cassettes) in tests of
+// plugins, so that they run offline and don't depend on the remote service.
+//
+// A test uses a cassette like this:
+//
+//	func TestGif(t *testing.T) {
+//		cassette := webtest.Use(t, "gif")
+//		cassette.IgnoreQuery("api_key")
+//		...
+//	}
+//
+// Requests of the web package are then answered from
+// testdata/cassettes/gif.json. A request which is not in the cassette fails
+// the test. Running the tests with WEBTEST_RECORD=1 sends the requests to
+// real servers and writes the cassette instead.
+package webtest
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/go-chat-bot/plugins/web"
+)
+
+const (
+	recordEnv    = "WEBTEST_RECORD"
+	cassetteDir  = "testdata/cassettes"
+	redactedText = "REDACTED"
+)
+
+// Interaction is a recorded request and its response
+type Interaction struct {
+	Request  Request  `json:"request"`
+	Response Response `json:"response"`
+}
+
+// Request identifies a request by method and URL
+type Request struct {
+	Method string `json:"method"`
+	URL    string `json:"url"`
+}
+
+// Response is replayed for the matching request. Body is kept as text unless
+// it is binary, then it is in BodyBase64.
+type Response struct {
+	StatusCode int         `json:"statusCode"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       string      `json:"body,omitempty"`
+	BodyBase64 string      `json:"bodyBase64,omitempty"`
+}
+
+// Cassette is http.RoundTripper replaying or recording interactions
+type Cassette struct {
+	mu           sync.Mutex
+	t            testing.TB
+	file         string
+	recording    bool
+	transport    http.RoundTripper // sends requests when recording
+	interactions []Interaction
+	used         []bool
+	ignored      map[string]bool // query parameters
+}
+
+// New loads cassette name of the test. When WEBTEST_RECORD env variable is
+// set the cassette records requests instead and is saved when the test
+// ends.
+func New(t testing.TB, name string) *Cassette {
+	t.Helper()
+	c := &Cassette{
+		t:         t,
+		file:      filepath.Join(cassetteDir, name+".json"),
+		transport: http.DefaultTransport,
+		ignored:   make(map[string]bool),
+	}
+	if os.Getenv(recordEnv) != "" {
+		c.recording = true
+		t.Cleanup(c.save)
+		return c
+	}
+	data, err := ioutil.ReadFile(c.file)
+	if err != nil {
+		t.Fatalf("Failed to load cassette, record it with %s=1: %v", recordEnv, err)
+	}
+	if err := json.Unmarshal(data, &c.interactions); err != nil {
+		t.Fatalf("Failed to parse cassette %s: %v", c.file, err)
+	}
+	c.used = make([]bool, len(c.interactions))
+	return c
+}
+
+// Use is New which also makes web.DefaultClient use the cassette until the
+// test ends
+func Use(t testing.TB, name string) *Cassette {
+	t.Helper()
+	c := New(t, name)
+	web.SetHTTPClient(c.Client())
+	t.Cleanup(func() { web.SetHTTPClient(nil) })
+	return c
+}
+
+// IgnoreQuery makes values of query parameters such as API keys irrelevant
+// for matching. They are replaced by REDACTED in recorded cassettes.
+func (c *Cassette) IgnoreQuery(names ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, name := range names {
+		c.ignored[name] = true
+	}
+}
+
+// Client returns HTTP client sending requests through the cassette
+func (c *Cassette) Client() *http.Client {
+	return &http.Client{Transport: c}
+}
+
+// redact returns rawURL with values of ignored query parameters replaced.
+// Cassette must be locked.
+func (c *Cassette) redact(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.RawQuery == "" {
+		return rawURL
+	}
+	query := u.Query()
+	for name := range query {
+		if c.ignored[name] {
+			query.Set(name, redactedText)
+		}
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// RoundTrip answers the request with the first unused interaction with the
+// same method and URL. Requests which are not in the cassette fail the test.
+func (c *Cassette) RoundTrip(req *http.Request) (*http.Response, error) {
+	if c.recording {
+		return c.record(req)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	recorded := Request{Method: req.Method, URL: c.redact(req.URL.String())}
+	for i, interaction := range c.interactions {
+		if !c.used[i] && interaction.Request.Method == recorded.Method &&
+			c.redact(interaction.Request.URL) == recorded.URL {
+			c.used[i] = true
+			return interaction.Response.httpResponse(req)
+		}
+	}
+	c.t.Errorf("Unexpected request %s %s, it is not in cassette %s", recorded.Method,
+		recorded.URL, c.file)
+	return nil, fmt.Errorf("request %s %s is not in cassette %s", recorded.Method,
+		recorded.URL, c.file)
+}
+
+func (r Response) httpResponse(req *http.Request) (*http.Response, error) {
+	body := []byte(r.Body)
+	if r.BodyBase64 != "" {
+		var err error
+		if body, err = base64.StdEncoding.DecodeString(r.BodyBase64); err != nil {
+			return nil, err
+		}
+	}
+	header := r.Header
+	if header == nil {
+		header = http.Header{}
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", r.StatusCode, http.StatusText(r.StatusCode)),
+		StatusCode:    r.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header.Clone(),
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+func (c *Cassette) record(req *http.Request) (*http.Response, error) {
+	res, err := c.transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	res.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	recorded := Response{StatusCode: res.StatusCode, Header: res.Header.Clone()}
+	recorded.Header.Del("Set-Cookie")
+	if utf8.Valid(body) {
+		recorded.Body = string(body)
+	} else {
+		recorded.BodyBase64 = base64.StdEncoding.EncodeToString(body)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.interactions = append(c.interactions, Interaction{
+		Request:  Request{Method: req.Method, URL: c.redact(req.URL.String())},
+		Response: recorded,
+	})
+	return res, nil
+}
+
+// save writes recorded interactions to the cassette file
+func (c *Cassette) save() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	data, err := json.MarshalIndent(c.interactions, "", "  ")
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(c.file), 0755)
+	}
+	if err == nil {
+		err = ioutil.WriteFile(c.file, append(data, '\n'), 0644)
+	}
+	if err != nil {
+		c.t.Errorf("Failed to save cassette %s: %v", c.file, err)
+	}
+}
@@ -0,0 +1,102 @@
+package webtest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-chat-bot/plugins/web"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// fakeT collects errors and cleanups instead of failing the test
+type fakeT struct {
+	testing.TB
+	errors   []string
+	cleanups []func()
+}
+
+func (t *fakeT) Errorf(format string, args ...interface{}) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+func (t *fakeT) Cleanup(f func()) {
+	t.cleanups = append(t.cleanups, f)
+}
+
+func (t *fakeT) cleanup() {
+	for i := len(t.cleanups) - 1; i >= 0; i-- {
+		t.cleanups[i]()
+	}
+}
+
+func TestCassette(t *testing.T) {
+	Convey("Given a recorded cassette", t, func() {
+		fake := &fakeT{TB: t}
+		Reset(fake.cleanup)
+		cassette := Use(fake, "example")
+		cassette.IgnoreQuery("api_key")
+		var data struct{ Name string }
+
+		Convey("Requests are answered from the cassette", func() {
+			err := web.GetJSON("https://api.example.com/search?q=cat&api_key=secret", &data)
+			So(err, ShouldBeNil)
+			So(data.Name, ShouldEqual, "cat")
+
+			_, err = web.GetBody("https://api.example.com/missing")
+			So(err, ShouldResemble, &web.StatusError{URL: "https://api.example.com/missing", StatusCode: 404})
+			So(fake.errors, ShouldBeEmpty)
+		})
+
+		Convey("Unexpected requests fail the test", func() {
+			_, err := cassette.Client().Get("https://api.example.com/other")
+			So(err, ShouldNotBeNil)
+			So(fake.errors, ShouldHaveLength, 1)
+		})
+
+		Convey("Each interaction is replayed once", func() {
+			web.GetJSON("https://api.example.com/search?q=cat&api_key=secret", &data)
+			_, err := cassette.Client().Get("https://api.example.com/search?q=cat&api_key=secret")
+			So(err, ShouldNotBeNil)
+			So(fake.errors, ShouldHaveLength, 1)
+		})
+	})
+
+	Convey("When recording", t, func() {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("hello " + r.URL.Query().Get("name")))
+		}))
+		defer ts.Close()
+		dir, _ := ioutil.TempDir("", "webtest")
+		defer os.RemoveAll(dir)
+		wd, _ := os.Getwd()
+		os.Chdir(dir)
+		defer os.Chdir(wd)
+		os.Setenv(recordEnv, "1")
+		defer os.Unsetenv(recordEnv)
+
+		fake := &fakeT{TB: t}
+		cassette := New(fake, "recorded")
+		cassette.IgnoreQuery("token")
+		res, err := cassette.Client().Get(ts.URL + "/?name=bot&token=secret")
+		So(err, ShouldBeNil)
+		body, _ := ioutil.ReadAll(res.Body)
+		So(string(body), ShouldEqual, "hello bot")
+		fake.cleanup()
+
+		Convey("Interactions are saved without ignored values", func() {
+			os.Unsetenv(recordEnv)
+			replay := New(fake, "recorded")
+			So(replay.interactions, ShouldHaveLength, 1)
+			So(replay.interactions[0].Request.URL, ShouldEqual, ts.URL+"/?name=bot&token=REDACTED")
+			So(replay.interactions[0].Response.Body, ShouldEqual, "hello bot")
+			_, err := os.Stat(filepath.Join(dir, cassetteDir, "recorded.json"))
+			So(err, ShouldBeNil)
+			So(fake.errors, ShouldBeEmpty)
+		})
+	})
+}